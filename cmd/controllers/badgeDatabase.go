@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// defaultBadges are awarded by every school unless it defines a badge with
+// the same key.
+var defaultBadges = []*models.Badge{
+	{
+		Key:         "deans_list",
+		Name:        "Dean's List",
+		Description: "A term GPA of 3.5 or better on a full course load.",
+		Rule:        models.BadgeRule{Kind: models.BadgeTermGPA, MinGPA: 3.5, MinCredits: 12},
+	},
+	{
+		Key:         "honor_roll_streak",
+		Name:        "Honor Roll Streak",
+		Description: "Three consecutive terms on the honor roll.",
+		Rule:        models.BadgeRule{Kind: models.BadgeStreak, MinGPA: 3.0, Terms: 3},
+	},
+	{
+		Key:         "most_improved",
+		Name:        "Most Improved",
+		Description: "Raised term GPA by half a point or more.",
+		Rule:        models.BadgeRule{Kind: models.BadgeImprovement, MinImprovement: 0.5, MinCredits: 6},
+	},
+}
+
+var badgeKeyPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// loadBadges returns the badges in force for a school: its own definitions
+// plus any default badge it has not overridden.
+func loadBadges(db *sql.DB, school int) ([]*models.Badge, error) {
+	rows, err := db.Query("SELECT badge_key, name, description, rule FROM leaderboard.badges WHERE school_id = ?", school)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	badges := make([]*models.Badge, 0)
+	defined := make(map[string]bool)
+	for rows.Next() {
+		badge := new(models.Badge)
+		var rule string
+		if err := rows.Scan(&badge.Key, &badge.Name, &badge.Description, &rule); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rule), &badge.Rule); err != nil {
+			log.Println("BADGE " + badge.Key + ": " + err.Error())
+			continue
+		}
+		badges = append(badges, badge)
+		defined[badge.Key] = true
+	}
+	for _, badge := range defaultBadges {
+		if !defined[badge.Key] {
+			badges = append(badges, badge)
+		}
+	}
+	sort.Slice(badges, func(i, j int) bool { return badges[i].Key < badges[j].Key })
+	return badges, rows.Err()
+}
+
+// earnedTerms returns the terms for which a student has earned the badge.
+// terms must be ordered oldest first.
+func earnedTerms(rule models.BadgeRule, terms []*models.TermRecord) []string {
+	earned := make([]string, 0)
+	streak := 0
+	for i, term := range terms {
+		switch rule.Kind {
+		case models.BadgeTermGPA:
+			if term.GPA >= rule.MinGPA && term.Credits >= rule.MinCredits {
+				earned = append(earned, term.Term)
+			}
+		case models.BadgeStreak:
+			if term.GPA >= rule.MinGPA && term.Credits >= rule.MinCredits {
+				streak++
+			} else {
+				streak = 0
+			}
+			if rule.Terms > 0 && streak == rule.Terms {
+				earned = append(earned, term.Term)
+			}
+		case models.BadgeImprovement:
+			if i == 0 {
+				continue
+			}
+			prev := terms[i-1]
+			if prev.Credits >= rule.MinCredits && term.Credits >= rule.MinCredits &&
+				term.GPA-prev.GPA >= rule.MinImprovement {
+				earned = append(earned, term.Term)
+			}
+		}
+	}
+	return earned
+}
+
+// evaluateBadges awards any badge the student has newly earned. Awards are
+// never revoked, so correcting a term later does not take a badge away.
+func evaluateBadges(db *sql.DB, school int, studentID int) error {
+	badges, err := loadBadges(db, school)
+	if err != nil {
+		return err
+	}
+	terms, err := loadTerms(db, "student_id = ? AND school_id = ?", studentID, school)
+	if err != nil {
+		return err
+	}
+	// loadTerms returns the most recent term first.
+	for i, j := 0, len(terms)-1; i < j; i, j = i+1, j-1 {
+		terms[i], terms[j] = terms[j], terms[i]
+	}
+
+	for _, badge := range badges {
+		for _, term := range earnedTerms(badge.Rule, terms) {
+			res, err := db.Exec("INSERT IGNORE INTO leaderboard.badge_awards(school_id, student_id, badge_key, term) VALUES(?, ?, ?, ?)",
+				school, studentID, badge.Key, term)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				log.Println("AWARD: " + badge.Name + " | Term: " + term)
+			}
+		}
+	}
+	return nil
+}
+
+// loadAwards returns the awards matching the where clause, newest first.
+func loadAwards(db *sql.DB, where string, args ...interface{}) ([]*models.BadgeAward, error) {
+	rows, err := db.Query("SELECT id, student_id, badge_key, term, awarded_at FROM leaderboard.badge_awards WHERE "+
+		where+" ORDER BY awarded_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	awards := make([]*models.BadgeAward, 0)
+	for rows.Next() {
+		award := new(models.BadgeAward)
+		var awardedAt mysql.NullTime
+		if err := rows.Scan(&award.ID, &award.StudentID, &award.Badge, &award.Term, &awardedAt); err != nil {
+			return nil, err
+		}
+		award.AwardedAt = awardedAt.Time
+		awards = append(awards, award)
+	}
+	return awards, rows.Err()
+}
+
+/******************************************************************************/
+
+func IndexBadges(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	badges, err := loadBadges(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, badges, func(i int) []interface{} { return []interface{}{badges[i].Key} }, []bool{false})
+}
+
+// InsertBadge defines a badge for the school, replacing the definition (or
+// the default badge) with the same key.
+func InsertBadge(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var badge models.Badge
+	if err := json.NewDecoder(r.Body).Decode(&badge); err != nil {
+		invalid(w, err)
+		return
+	}
+	faults := required("name", badge.Name)
+	if !badgeKeyPattern.MatchString(badge.Key) {
+		faults = append(faults, &models.FieldError{Field: "key", Detail: "must be lower_snake_case"})
+	}
+	if len(faults) > 0 {
+		invalidFields(w, faults...)
+		return
+	}
+	switch badge.Rule.Kind {
+	case models.BadgeTermGPA, models.BadgeImprovement:
+	case models.BadgeStreak:
+		if badge.Rule.Terms < 1 {
+			invalidField(w, "rule.terms", "streak rules need terms of at least 1")
+			return
+		}
+	default:
+		invalidField(w, "rule.kind", "must be one of term_gpa, streak or improvement")
+		return
+	}
+
+	rule, _ := json.Marshal(badge.Rule)
+	if _, err := db.Exec("INSERT INTO leaderboard.badges(school_id, badge_key, name, description, rule) VALUES(?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE name = VALUES(name), description = VALUES(description), rule = VALUES(rule)",
+		tenantOf(r).SchoolID, badge.Key, badge.Name, badge.Description, string(rule)); err != nil {
+		serverError(w, err)
+		return
+	}
+	log.Println("INSERT BADGE: " + badge.Key)
+	writeJSON(w, http.StatusCreated, badge)
+}
+
+func StudentBadges(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	awards, err := loadAwards(db, "student_id = ? AND school_id = ?", mux.Vars(r)["studentId"], tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, awards, func(i int) []interface{} {
+		return []interface{}{micros(awards[i].AwardedAt), awards[i].ID}
+	}, []bool{true, true})
+}
+
+// BadgeHolders lists every student holding a badge, most recently awarded
+// first.
+func BadgeHolders(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	school := tenantOf(r).SchoolID
+	awards, err := loadAwards(db, "badge_key = ? AND school_id = ?", mux.Vars(r)["badgeKey"], school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	stus, err := loadStudents(db, "school_id = ? AND id IN (SELECT student_id FROM leaderboard.badge_awards WHERE badge_key = ?)",
+		school, mux.Vars(r)["badgeKey"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	byID := make(map[int]*models.Student)
+	for _, stu := range stus {
+		byID[stu.ID] = stu
+	}
+
+	holders := make([]*models.BadgeHolder, 0)
+	seen := make(map[int]*models.BadgeHolder)
+	for _, award := range awards {
+		holder, ok := seen[award.StudentID]
+		if !ok {
+			if byID[award.StudentID] == nil {
+				continue
+			}
+			holder = &models.BadgeHolder{Student: byID[award.StudentID]}
+			seen[award.StudentID] = holder
+			holders = append(holders, holder)
+		}
+		holder.Awards = append(holder.Awards, award)
+	}
+	writePage(w, r, holders, func(i int) []interface{} {
+		latest := holders[i].Awards[0]
+		return []interface{}{micros(latest.AwardedAt), latest.ID}
+	}, []bool{true, true})
+}
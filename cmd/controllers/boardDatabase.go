@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+var boardSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// builtinBoards are available to every school and cannot be redefined.
+var builtinBoards = map[string]*models.Leaderboard{
+	"gpa": {
+		Slug:        "gpa",
+		Name:        "GPA",
+		Score:       "gpa",
+		Direction:   "desc",
+		TieBreakers: boardTieBreakers["gpa"],
+		TiePolicy:   models.TiePolicyShared,
+		Visibility:  models.VisibilityPublic,
+		BuiltIn:     true,
+	},
+}
+
+// canView reports whether the caller may see a board.
+func canView(tenant *models.Tenant, board *models.Leaderboard) bool {
+	switch board.Visibility {
+	case models.VisibilityPublic:
+		return true
+	case models.VisibilityStaff:
+		return tenant.Username != ""
+	}
+	return tenant.Role == models.RoleAdmin
+}
+
+// loadBoards returns the school's saved boards matching the where clause.
+func loadBoards(db *sql.DB, school int, where string, args ...interface{}) ([]*models.Leaderboard, error) {
+	query := "SELECT definition FROM leaderboard.boards WHERE school_id = ?"
+	if where != "" {
+		query += " AND " + where
+	}
+	rows, err := db.Query(query+" ORDER BY slug", append([]interface{}{school}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boards := make([]*models.Leaderboard, 0)
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return nil, err
+		}
+		board := new(models.Leaderboard)
+		if err := json.Unmarshal([]byte(definition), board); err != nil {
+			return nil, err
+		}
+		boards = append(boards, board)
+	}
+	return boards, rows.Err()
+}
+
+// schoolBoards returns every board of the school: the built-in boards, the
+// boards of its classes yet to graduate and its saved boards.
+func schoolBoards(db *sql.DB, school int) ([]*models.Leaderboard, error) {
+	classes, err := classBoards(db, school)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := loadBoards(db, school, "")
+	if err != nil {
+		return nil, err
+	}
+	boards := make([]*models.Leaderboard, 0, len(builtinBoards)+len(classes)+len(saved))
+	for _, board := range builtinBoards {
+		boards = append(boards, board)
+	}
+	boards = append(boards, classes...)
+	return append(boards, saved...), nil
+}
+
+// resolveBoard returns the built-in, class-year or saved board with the
+// given slug, or nil when there is none.
+func resolveBoard(db *sql.DB, school int, slug string) (*models.Leaderboard, error) {
+	if board, ok := builtinBoards[slug]; ok {
+		return board, nil
+	}
+	if m := classBoardPattern.FindStringSubmatch(slug); m != nil {
+		// Only the classes classBoards lists have boards, so that a slug
+		// for any other year is unknown rather than an empty board.
+		gradYear, _ := strconv.Atoi(m[1])
+		if ok, err := hasClass(db, school, gradYear); err != nil || !ok {
+			return nil, err
+		}
+		return classBoard(gradYear), nil
+	}
+	boards, err := loadBoards(db, school, "slug = ?", slug)
+	if err != nil || len(boards) == 0 {
+		return nil, err
+	}
+	return boards[0], nil
+}
+
+// validateBoard normalises a definition and checks it is complete.
+func validateBoard(db *sql.DB, school int, board *models.Leaderboard) error {
+	if !boardSlugPattern.MatchString(board.Slug) {
+		return &QueryError{"slug", -1, "must be lower case letters, digits and dashes"}
+	}
+	if _, ok := builtinBoards[board.Slug]; ok || classBoardPattern.MatchString(board.Slug) {
+		return &QueryError{"slug", -1, strconv.Quote(board.Slug) + " is reserved"}
+	}
+	if board.Name == "" {
+		return &QueryError{"name", -1, "is required"}
+	}
+	if board.Score == "" {
+		board.Score = "gpa"
+	}
+	if _, err := scoreSource(db, school, board.Score); err != nil {
+		return err
+	}
+	switch board.Direction {
+	case "":
+		board.Direction = "desc"
+	case "asc", "desc":
+	default:
+		return &QueryError{"direction", -1, "must be asc or desc"}
+	}
+	if board.TieBreakers == nil {
+		board.TieBreakers = make([]string, 0)
+	}
+	if len(board.TieBreakers) > 0 {
+		if _, err := parseTieBreakers("tie_breakers", strings.Join(board.TieBreakers, ",")); err != nil {
+			return err
+		}
+	}
+	switch board.TiePolicy {
+	case "":
+		board.TiePolicy = models.TiePolicyShared
+	case models.TiePolicyShared, models.TiePolicyDense, models.TiePolicyUnique:
+	default:
+		return &QueryError{"tie_policy", -1, "must be one of shared, dense or unique"}
+	}
+	switch board.Visibility {
+	case "":
+		board.Visibility = models.VisibilityPublic
+	case models.VisibilityPublic, models.VisibilityStaff, models.VisibilityAdmin:
+	default:
+		return &QueryError{"visibility", -1, "must be one of public, staff or admin"}
+	}
+	if _, err := compileFilter(board.Filter.Query); err != nil {
+		if queryErr, ok := err.(*QueryError); ok {
+			queryErr.Param = "filter.query"
+		}
+		return err
+	}
+	for i, level := range board.Filter.Levels {
+		board.Filter.Levels[i] = strings.ToLower(level)
+	}
+	board.BuiltIn = false
+	return nil
+}
+
+// filterStudents keeps the students matching a board filter.
+func filterStudents(db *sql.DB, school int, filter models.BoardFilter, stus []*models.Student) ([]*models.Student, error) {
+	query, err := compileFilter(filter.Query)
+	if err != nil {
+		return nil, err
+	}
+	var onTeam map[int]bool
+	if len(filter.TeamIDs) > 0 || len(filter.Levels) > 0 {
+		teams, err := loadTeams(db, "school_id = ?", school)
+		if err != nil {
+			return nil, err
+		}
+		onTeam = make(map[int]bool)
+		for _, team := range teams {
+			if len(filter.TeamIDs) > 0 && !containsInt(filter.TeamIDs, team.ID) {
+				continue
+			}
+			if len(filter.Levels) > 0 && !containsString(filter.Levels, team.Level) {
+				continue
+			}
+			for _, id := range team.Members {
+				onTeam[id] = true
+			}
+		}
+	}
+
+	kept := make([]*models.Student, 0, len(stus))
+	for _, stu := range stus {
+		gpa, credits := float64(stu.GPA), float64(stu.Credits)
+		switch {
+		case len(filter.Sports) > 0 && !containsFold(filter.Sports, stu.Sport):
+		case len(filter.GradYears) > 0 && !containsInt(filter.GradYears, stu.GradYear):
+		case onTeam != nil && !onTeam[stu.ID]:
+		case filter.MinGPA != nil && gpa < *filter.MinGPA:
+		case filter.MaxGPA != nil && gpa > *filter.MaxGPA:
+		case filter.MinCredits != nil && credits < *filter.MinCredits:
+		case query != nil && !query.match(stu):
+		default:
+			kept = append(kept, stu)
+		}
+	}
+	return kept, nil
+}
+
+// computeBoard ranks the school's current students on a board.
+func computeBoard(db *sql.DB, school int, board *models.Leaderboard) ([]*models.LeaderboardEntry, error) {
+	stus, err := loadStudents(db, currentStudents, school)
+	if err != nil {
+		return nil, err
+	}
+	if stus, err = filterStudents(db, school, board.Filter, stus); err != nil {
+		return nil, err
+	}
+	scoreOf, err := scoreSource(db, school, board.Score)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := loadEligibility(db, school)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.LeaderboardEntry, 0, len(stus))
+	for _, stu := range stus {
+		if score, ok := scoreOf(stu); ok {
+			entries = append(entries, &models.LeaderboardEntry{Student: stu, Score: score, Eligibility: ev.evaluate(stu, "")})
+		}
+	}
+	entries = rankEntries(entries, board.Direction == "asc", board.TieBreakers)
+	applyTiePolicy(entries, board.TiePolicy)
+	return entries, nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+/******************************************************************************/
+
+// IndexBoards lists the boards the caller may view.
+func IndexBoards(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	tenant := tenantOf(r)
+	all, err := schoolBoards(db, tenant.SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	boards := make([]*models.Leaderboard, 0, len(all))
+	for _, board := range all {
+		if canView(tenant, board) {
+			boards = append(boards, board)
+		}
+	}
+	sort.Slice(boards, func(i, j int) bool { return boards[i].Slug < boards[j].Slug })
+	writePage(w, r, boards, func(i int) []interface{} { return []interface{}{boards[i].Slug} }, []bool{false})
+}
+
+// FetchBoard serves a board's definition and current standings.
+func FetchBoard(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	tenant := tenantOf(r)
+	board, err := resolveBoard(db, tenant.SchoolID, mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if board == nil || !canView(tenant, board) {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	entries, err := computeBoard(db, tenant.SchoolID, board)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	keyOf, desc := entryKeys(entries, board.Direction == "asc", board.TieBreakers)
+	start, end, ok := paginate(w, r, len(entries), keyOf, desc)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, &models.BoardStandings{Board: board, Entries: entries[start:end]})
+}
+
+// SaveBoard creates (POST) or replaces (PUT) a board definition. Only
+// administrators may define boards.
+func SaveBoard(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	var board models.Leaderboard
+	if err := json.NewDecoder(r.Body).Decode(&board); err != nil {
+		invalid(w, err)
+		return
+	}
+	if slug, ok := mux.Vars(r)["slug"]; ok {
+		board.Slug = slug
+	}
+	if err := validateBoard(db, tenant.SchoolID, &board); err != nil {
+		requestError(w, err)
+		return
+	}
+	definition, _ := json.Marshal(board)
+
+	status := http.StatusCreated
+	if r.Method == http.MethodPut {
+		res, err := db.Exec("UPDATE leaderboard.boards SET definition = ? WHERE school_id = ? AND slug = ?",
+			string(definition), tenant.SchoolID, board.Slug)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			problem(w, http.StatusNotFound, "")
+			return
+		}
+		status = http.StatusOK
+	} else if _, err := db.Exec("INSERT INTO leaderboard.boards(school_id, slug, definition, created_by) VALUES(?, ?, ?, ?)",
+		tenant.SchoolID, board.Slug, string(definition), tenant.Username); err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+			problem(w, http.StatusConflict, "board "+strconv.Quote(board.Slug)+" already exists")
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenant.SchoolID)
+	log.Println("SAVE BOARD: " + board.Slug + " | By: " + tenant.Username)
+	writeJSON(w, status, board)
+}
+
+func DeleteBoard(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM leaderboard.boards WHERE school_id = ? AND slug = ?",
+		tenant.SchoolID, mux.Vars(r)["slug"]); err != nil {
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenant.SchoolID)
+	log.Println("DELETE BOARD: " + mux.Vars(r)["slug"])
+	w.WriteHeader(http.StatusNoContent)
+}
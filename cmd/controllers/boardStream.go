@@ -0,0 +1,442 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How many recent events each board keeps for resuming streams.
+	feedBacklog = 500
+	// How many events a subscriber may fall behind before it is dropped and
+	// left to reconnect.
+	subscriberBuffer = 64
+	// How often an idle stream gets a comment, so proxies keep it open.
+	heartbeatInterval = 15 * time.Second
+)
+
+// Event IDs are "<epoch>-<sequence>". The epoch changes whenever the server
+// starts and whenever a dropped feed is created again, so IDs handed out by
+// an earlier feed are never mistaken for new ones.
+var feedEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// How many feeds have been created. boardFeeds guards it.
+var feedsCreated int
+
+type boardEvent struct {
+	epoch string
+	seq   int
+	kind  string
+	data  []byte
+}
+
+func (e *boardEvent) id() string {
+	return e.epoch + "-" + strconv.Itoa(e.seq)
+}
+
+// writeTo writes e in the text/event-stream format.
+func (e *boardEvent) writeTo(w io.Writer) error {
+	_, err := io.WriteString(w, "id: "+e.id()+"\nevent: "+e.kind+"\ndata: "+string(e.data)+"\n\n")
+	return err
+}
+
+// A boardFeed follows one board's standings for its subscribers. The board
+// is recomputed once per change, however many subscribers there are, and
+// only while anyone is subscribed or a webhook watches it. Once nothing
+// holds the feed it is dropped, and whoever follows the board next starts
+// from a snapshot.
+type boardFeed struct {
+	school int
+	slug   string
+	epoch  string
+
+	// Serialises refreshes, which are slow and happen outside mu.
+	refreshing sync.Mutex
+
+	mu          sync.Mutex
+	board       *models.Leaderboard
+	standings   []*models.LeaderboardEntry
+	computed    bool
+	seq         int
+	events      []*boardEvent
+	subscribers map[chan *boardEvent]bool
+	// Whether webhooks watch the board, keeping the feed running without
+	// subscribers. Set holding both mu and boardFeeds, so either will do
+	// for reading it.
+	pinned bool
+	dirty  chan struct{}
+	quit   chan struct{}
+
+	// How many streams hold the feed. boardFeeds guards it.
+	holders int
+}
+
+type feedKey struct {
+	school int
+	slug   string
+}
+
+var boardFeeds = struct {
+	sync.Mutex
+	byKey map[feedKey]*boardFeed
+}{byKey: make(map[feedKey]*boardFeed)}
+
+// feedFor returns the board's feed, creating it if need be. boardFeeds
+// must be held.
+func feedFor(school int, slug string) *boardFeed {
+	key := feedKey{school, slug}
+	if boardFeeds.byKey[key] == nil {
+		feedsCreated++
+		boardFeeds.byKey[key] = &boardFeed{
+			school:      school,
+			slug:        slug,
+			epoch:       feedEpoch + "." + strconv.Itoa(feedsCreated),
+			subscribers: make(map[chan *boardEvent]bool),
+			dirty:       make(chan struct{}, 1),
+		}
+	}
+	return boardFeeds.byKey[key]
+}
+
+// acquireFeed returns the board's feed, which is kept until the caller
+// releases it.
+func acquireFeed(school int, slug string) *boardFeed {
+	boardFeeds.Lock()
+	defer boardFeeds.Unlock()
+	f := feedFor(school, slug)
+	f.holders++
+	return f
+}
+
+// releaseFeed lets go of a feed taken with acquireFeed.
+func releaseFeed(f *boardFeed) {
+	boardFeeds.Lock()
+	defer boardFeeds.Unlock()
+	f.holders--
+	f.dropIdle()
+}
+
+// dropIdle forgets the feed once no stream holds it and no webhook watches
+// it, so that feeds do not pile up for every board anyone has followed.
+// boardFeeds must be held.
+func (f *boardFeed) dropIdle() {
+	if f.holders == 0 && !f.pinned {
+		delete(boardFeeds.byKey, feedKey{f.school, f.slug})
+	}
+}
+
+// pinFeed keeps the board's feed running for webhooks, or lets it go.
+func pinFeed(school int, slug string, pinned bool) {
+	boardFeeds.Lock()
+	defer boardFeeds.Unlock()
+	f := boardFeeds.byKey[feedKey{school, slug}]
+	if f == nil && !pinned {
+		return
+	}
+	if f == nil {
+		f = feedFor(school, slug)
+	}
+	f.pin(pinned)
+	f.dropIdle()
+}
+
+// boardsChanged is called after any write that may move a school's
+// standings, so that its cached responses are dropped, its rank history is
+// recorded and the boards being streamed are recomputed. It does not wait
+// for them.
+func boardsChanged(school int) {
+	invalidateResponses(school)
+	historyChanged(school)
+	boardFeeds.Lock()
+	defer boardFeeds.Unlock()
+	for key, feed := range boardFeeds.byKey {
+		if key.school != school {
+			continue
+		}
+		select {
+		case feed.dirty <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// diffStandings lists the changes from one set of standings to the next:
+// removals first, then the new standings' changes in rank order.
+func diffStandings(before, after []*models.LeaderboardEntry) []*models.BoardChange {
+	previous := make(map[int]*models.LeaderboardEntry)
+	for _, entry := range before {
+		previous[entry.Student.ID] = entry
+	}
+	changes := make([]*models.BoardChange, 0)
+	current := make(map[int]bool)
+	for _, entry := range after {
+		current[entry.Student.ID] = true
+	}
+	for _, entry := range before {
+		if !current[entry.Student.ID] {
+			score := entry.Score
+			changes = append(changes, &models.BoardChange{StudentID: entry.Student.ID, PreviousRank: entry.Rank, PreviousScore: &score})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].PreviousRank < changes[j].PreviousRank })
+	for _, entry := range after {
+		old, ok := previous[entry.Student.ID]
+		switch {
+		case !ok:
+			changes = append(changes, &models.BoardChange{StudentID: entry.Student.ID, Entry: entry})
+		case old.Rank != entry.Rank || old.Score != entry.Score:
+			score := old.Score
+			changes = append(changes, &models.BoardChange{StudentID: entry.Student.ID, Entry: entry,
+				PreviousRank: old.Rank, PreviousScore: &score})
+		}
+	}
+	return changes
+}
+
+// changeKind names the kind of event a change is published as.
+func changeKind(change *models.BoardChange) string {
+	switch {
+	case change.Entry == nil:
+		return models.BoardEventRemoval
+	case change.PreviousRank == 0:
+		return models.BoardEventEntry
+	}
+	return models.BoardEventRank
+}
+
+// publish records an event and hands it to every subscriber. Subscribers
+// too far behind are dropped. mu must be held.
+func (f *boardFeed) publish(kind string, v interface{}) {
+	data, _ := json.Marshal(v)
+	f.seq++
+	event := &boardEvent{epoch: f.epoch, seq: f.seq, kind: kind, data: data}
+	f.events = append(f.events, event)
+	if len(f.events) > feedBacklog {
+		f.events = f.events[len(f.events)-feedBacklog:]
+	}
+	for sub := range f.subscribers {
+		select {
+		case sub <- event:
+		default:
+			close(sub)
+			delete(f.subscribers, sub)
+		}
+	}
+}
+
+// refresh recomputes the board and publishes what changed since it was last
+// computed, to subscribers and to webhooks. A board that no longer exists
+// has no entries.
+func (f *boardFeed) refresh() error {
+	f.refreshing.Lock()
+	defer f.refreshing.Unlock()
+
+	db := dbConn()
+	defer db.Close()
+	board, err := resolveBoard(db, f.school, f.slug)
+	if err != nil {
+		return err
+	}
+	entries := make([]*models.LeaderboardEntry, 0)
+	if board != nil {
+		if entries, err = computeBoard(db, f.school, board); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	var changes []*models.BoardChange
+	if f.computed {
+		changes = diffStandings(f.standings, entries)
+		for _, change := range changes {
+			f.publish(changeKind(change), change)
+		}
+	}
+	f.board, f.standings, f.computed = board, entries, true
+	f.mu.Unlock()
+
+	notifyWebhooks(f.school, f.slug, changes)
+	return nil
+}
+
+// run refreshes the feed whenever the school's data changes, until quit is
+// closed.
+func (f *boardFeed) run(quit chan struct{}) {
+	for {
+		select {
+		case <-quit:
+			return
+		case <-f.dirty:
+			if err := f.refresh(); err != nil {
+				log.Println("BOARD STREAM: " + f.slug + " | " + err.Error())
+			}
+		}
+	}
+}
+
+// backlog returns the events a subscriber resuming after lastID has missed,
+// or a snapshot of the standings when the events are no longer at hand. mu
+// must be held.
+func (f *boardFeed) backlog(lastID string) []*boardEvent {
+	parts := strings.SplitN(lastID, "-", 2)
+	if len(parts) == 2 && parts[0] == f.epoch {
+		seq, err := strconv.Atoi(parts[1])
+		oldest := f.seq + 1
+		if len(f.events) > 0 {
+			oldest = f.events[0].seq
+		}
+		if err == nil && seq <= f.seq && seq >= oldest-1 {
+			missed := make([]*boardEvent, 0)
+			for _, event := range f.events {
+				if event.seq > seq {
+					missed = append(missed, event)
+				}
+			}
+			return missed
+		}
+	}
+	data, _ := json.Marshal(&models.BoardStandings{Board: f.board, Entries: f.standings})
+	return []*boardEvent{{epoch: f.epoch, seq: f.seq, kind: models.BoardEventSnapshot, data: data}}
+}
+
+// start runs the feed if it is not running. mu must be held.
+func (f *boardFeed) start() {
+	if f.quit == nil {
+		f.quit = make(chan struct{})
+		go f.run(f.quit)
+	}
+}
+
+// stop stops running the feed once no one follows it. mu must be held.
+func (f *boardFeed) stop() {
+	if len(f.subscribers) == 0 && !f.pinned && f.quit != nil {
+		close(f.quit)
+		f.quit = nil
+	}
+}
+
+// subscribe starts following the board, bringing it up to date first if
+// no one was following it. It returns the events to send before the live
+// ones.
+func (f *boardFeed) subscribe(lastID string) (chan *boardEvent, []*boardEvent, error) {
+	f.mu.Lock()
+	live := f.quit != nil && f.computed
+	f.mu.Unlock()
+	if !live {
+		if err := f.refresh(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub := make(chan *boardEvent, subscriberBuffer)
+	f.subscribers[sub] = true
+	f.start()
+	return sub, f.backlog(lastID), nil
+}
+
+// unsubscribe stops following the board, and stops recomputing it when no
+// one is left.
+func (f *boardFeed) unsubscribe(sub chan *boardEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, sub)
+	f.stop()
+}
+
+// pin keeps the feed running for webhooks whether or not anyone is
+// subscribed, or lets it stop again. A newly pinned feed is brought up to
+// date in the background, so that later changes have something to be
+// compared with. boardFeeds must be held.
+func (f *boardFeed) pin(pinned bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pinned = pinned
+	if !pinned {
+		f.stop()
+		return
+	}
+	if f.quit == nil {
+		f.start()
+		select {
+		case f.dirty <- struct{}{}:
+		default:
+		}
+	}
+}
+
+/******************************************************************************/
+
+// BoardEvents streams a board's changes as server-sent events: a snapshot
+// of the standings, then an event for every entry, rank change and removal.
+// Reconnecting with Last-Event-ID resumes where the stream left off.
+func BoardEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	db := dbConn()
+	tenant := tenantOf(r)
+	board, err := resolveBoard(db, tenant.SchoolID, mux.Vars(r)["slug"])
+	db.Close()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if board == nil || !canView(tenant, board) {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+
+	feed := acquireFeed(tenant.SchoolID, board.Slug)
+	defer releaseFeed(feed)
+	sub, backlog, err := feed.subscribe(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer feed.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range backlog {
+		if event.writeTo(w) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub:
+			// A closed subscription fell too far behind; the client
+			// reconnects and resumes.
+			if !ok || event.writeTo(w) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
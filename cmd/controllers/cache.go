@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many responses are cached for each school. Once it is full, nothing
+// more is cached until the school's data next changes.
+const maxCachedResponses = 1000
+
+// Cache hits, misses and 304s, published at /api/metrics.
+var cacheCounts = expvar.NewMap("response_cache")
+
+// A successful response, kept until the school's data changes.
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+	etag   string
+}
+
+type schoolCache struct {
+	// Counts the changes, so that a response computed across a change is
+	// not kept.
+	generation int
+	// When the school's data last changed, to the second: Last-Modified
+	// for every response.
+	modified time.Time
+	// Whether Last-Modified tells copies apart: it does not once the data
+	// has changed twice in the same second.
+	exact     bool
+	responses map[string]*cachedResponse
+}
+
+var responseCache = struct {
+	sync.Mutex
+	bySchool map[int]*schoolCache
+}{bySchool: make(map[int]*schoolCache)}
+
+// Changes made before the server started are not known, so they are taken
+// to have been made then.
+var cacheStarted = time.Now().Truncate(time.Second)
+
+// cacheOf returns a school's cache. responseCache must be locked.
+func cacheOf(school int) *schoolCache {
+	c := responseCache.bySchool[school]
+	if c == nil {
+		c = &schoolCache{modified: cacheStarted, exact: true, responses: make(map[string]*cachedResponse)}
+		responseCache.bySchool[school] = c
+	}
+	return c
+}
+
+// invalidateResponses drops the school's cached responses after a change
+// to its data.
+func invalidateResponses(school int) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	c := cacheOf(school)
+	modified := time.Now().Truncate(time.Second)
+	c.generation++
+	c.modified, c.exact = modified, modified.After(c.modified)
+	c.responses = make(map[string]*cachedResponse)
+}
+
+// A ResponseWriter that keeps the response for the cache.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+}
+
+// notModified reports whether the client's copy is current. If-None-Match
+// wins over If-Modified-Since, and is compared weakly, as RFC 7232 asks;
+// If-Modified-Since is only trusted while Last-Modified is exact.
+func notModified(r *http.Request, etag string, modified time.Time, exact bool) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && exact && !modified.After(since)
+}
+
+// Cached serves GET requests from a cache of the school's responses,
+// which is dropped whenever its data changes. Responses carry a strong
+// ETag and a Last-Modified, and conditional requests for a copy that is
+// still current are answered 304. Responses are shared by everyone in the
+// school with the same role, so only routes whose responses depend on
+// nothing else may be cached.
+func Cached(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant := tenantOf(r)
+		key := tenant.Role + " v" + strconv.Itoa(apiVersion(r)) + " " + r.URL.RequestURI()
+
+		responseCache.Lock()
+		c := cacheOf(tenant.SchoolID)
+		cached, generation, modified, exact := c.responses[key], c.generation, c.modified, c.exact
+		responseCache.Unlock()
+
+		if cached == nil {
+			cacheCounts.Add("miss", 1)
+			rec := &responseRecorder{header: make(http.Header)}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.status != http.StatusOK {
+				copyHeader(w.Header(), rec.header)
+				w.WriteHeader(rec.status)
+				_, _ = w.Write(rec.body.Bytes())
+				return
+			}
+			sum := sha256.Sum256(rec.body.Bytes())
+			cached = &cachedResponse{header: rec.header, body: rec.body.Bytes(), etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+
+			responseCache.Lock()
+			if c := cacheOf(tenant.SchoolID); c.generation == generation && len(c.responses) < maxCachedResponses {
+				c.responses[key] = cached
+			}
+			responseCache.Unlock()
+		} else {
+			cacheCounts.Add("hit", 1)
+		}
+
+		w.Header().Set("ETag", cached.etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		// Clients may keep responses but must check they are current.
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if notModified(r, cached.etag, modified, exact) {
+			cacheCounts.Add("not_modified", 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		copyHeader(w.Header(), cached.header)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(cached.body)
+	})
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"database/sql"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Students move up a grade at the start of July, once the school year has
+// ended.
+const promotionMonth = time.July
+
+var classBoardPattern = regexp.MustCompile(`^class-([0-9]{4})$`)
+
+// schoolYearEnding returns the year in which the school year under way at t
+// ends.
+func schoolYearEnding(t time.Time) int {
+	if t.Month() >= promotionMonth {
+		return t.Year() + 1
+	}
+	return t.Year()
+}
+
+// cohortOf fills in whichever of a graduation year and grade level is
+// missing from the other, as of now, and checks that the two agree. Both may
+// be 0 when the student's cohort is unknown; graduates have a graduation
+// year but no grade level.
+func cohortOf(gradYear, gradeLevel int, now time.Time) (int, int, error) {
+	ending := schoolYearEnding(now)
+	if gradeLevel < 0 || gradeLevel > models.FinalGradeLevel {
+		return 0, 0, &QueryError{"grade_level", -1, "must be between 0 and " + strconv.Itoa(models.FinalGradeLevel)}
+	}
+	if gradYear == 0 {
+		if gradeLevel == 0 {
+			return 0, 0, nil
+		}
+		return ending + models.FinalGradeLevel - gradeLevel, gradeLevel, nil
+	}
+	derived := models.FinalGradeLevel - (gradYear - ending)
+	switch {
+	case derived > models.FinalGradeLevel && gradeLevel == 0:
+		return gradYear, 0, nil
+	case derived < 1:
+		return 0, 0, &QueryError{"grad_year", -1, strconv.Itoa(gradYear) + " is too far away"}
+	case gradeLevel != 0 && gradeLevel != derived:
+		return 0, 0, &QueryError{"grade_level", -1, strconv.Itoa(gradeLevel) + " does not graduate in " + strconv.Itoa(gradYear)}
+	}
+	return gradYear, derived, nil
+}
+
+// graduatedAt returns when a student of the cohort cohortOf returned is
+// archived: now for a graduate, and nil for everyone else.
+func graduatedAt(gradYear, gradeLevel int, now time.Time) interface{} {
+	if gradYear != 0 && gradeLevel == 0 {
+		return now
+	}
+	return nil
+}
+
+// classBoard is the built-in board ranking one graduating class by GPA.
+func classBoard(gradYear int) *models.Leaderboard {
+	year := strconv.Itoa(gradYear)
+	return &models.Leaderboard{
+		Slug:        "class-" + year,
+		Name:        "Class of " + year,
+		Filter:      models.BoardFilter{GradYears: []int{gradYear}},
+		Score:       "gpa",
+		Direction:   "desc",
+		TieBreakers: boardTieBreakers["gpa"],
+		TiePolicy:   models.TiePolicyShared,
+		Visibility:  models.VisibilityPublic,
+		BuiltIn:     true,
+	}
+}
+
+// classBoards returns the boards of the school's classes yet to graduate.
+func classBoards(db *sql.DB, school int) ([]*models.Leaderboard, error) {
+	rows, err := db.Query("SELECT DISTINCT grad_year FROM leaderboard.students WHERE "+currentStudents+
+		" AND grad_year > 0 ORDER BY grad_year", school)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boards := make([]*models.Leaderboard, 0)
+	for rows.Next() {
+		var gradYear int
+		if err := rows.Scan(&gradYear); err != nil {
+			return nil, err
+		}
+		boards = append(boards, classBoard(gradYear))
+	}
+	return boards, rows.Err()
+}
+
+// hasClass reports whether the school has a class graduating in gradYear
+// that is yet to graduate, that is whether classBoards lists its board.
+func hasClass(db *sql.DB, school int, gradYear int) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM leaderboard.students WHERE "+currentStudents+" AND grad_year = ?",
+		school, gradYear).Scan(&n)
+	return n > 0 && gradYear > 0, err
+}
+
+// promote advances the school past the school year ending in year: the
+// classes of that year and earlier are archived and leave their teams, and
+// everyone else's grade level is worked out afresh from their graduation
+// year. Running it again for the same year changes nothing.
+func promote(db *sql.DB, school int, year int, by string, now time.Time) (*models.Promotion, error) {
+	promotion := &models.Promotion{SchoolYear: year, PromotedBy: by, PromotedAt: now}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	graduates := "school_id = ? AND archived_at IS NULL AND grad_year > 0 AND grad_year <= ?"
+	if _, err := tx.Exec("DELETE FROM leaderboard.team_members WHERE student_id IN "+
+		"(SELECT id FROM leaderboard.students WHERE "+graduates+")", school, year); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec("UPDATE leaderboard.students SET archived_at = ? WHERE "+graduates, now, school, year)
+	if err != nil {
+		return nil, err
+	}
+	graduated, _ := res.RowsAffected()
+	res, err = tx.Exec("UPDATE leaderboard.students SET grade_level = ? - (grad_year - ?) WHERE "+currentStudents+
+		" AND grad_year > 0", models.FinalGradeLevel, year+1, school)
+	if err != nil {
+		return nil, err
+	}
+	promoted, _ := res.RowsAffected()
+	promotion.Promoted, promotion.Graduated = int(promoted), int(graduated)
+
+	res, err = tx.Exec("INSERT INTO leaderboard.promotions(school_id, school_year, promoted, graduated, promoted_by, promoted_at) "+
+		"VALUES(?, ?, ?, ?, ?, ?)", school, year, promotion.Promoted, promotion.Graduated, by, now)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	promotion.ID = int(id)
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	invalidateNameIndex(school)
+	boardsChanged(school)
+	log.Println("PROMOTE: School: " + strconv.Itoa(school) + " | Year: " + strconv.Itoa(year) +
+		" | Promoted: " + strconv.Itoa(promotion.Promoted) + " | Graduated: " + strconv.Itoa(promotion.Graduated))
+	return promotion, nil
+}
+
+// promoteDue promotes every school not yet promoted past the school year
+// that most recently ended.
+func promoteDue(db *sql.DB, now time.Time) error {
+	year := schoolYearEnding(now) - 1
+	rows, err := db.Query("SELECT s.id FROM leaderboard.schools s WHERE NOT EXISTS "+
+		"(SELECT 1 FROM leaderboard.promotions p WHERE p.school_id = s.id AND p.school_year = ?)", year)
+	if err != nil {
+		return err
+	}
+	schools := make([]int, 0)
+	for rows.Next() {
+		var school int
+		if err := rows.Scan(&school); err != nil {
+			rows.Close()
+			return err
+		}
+		schools = append(schools, school)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, school := range schools {
+		if _, err := promote(db, school, year, "", now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPromotions is the annual promotion job. It checks every interval
+// whether a school year has ended and promotes the schools that are due; it
+// never returns.
+func RunPromotions(interval time.Duration) {
+	for {
+		db := dbConn()
+		if err := promoteDue(db, time.Now()); err != nil {
+			log.Println("PROMOTE: " + err.Error())
+		}
+		db.Close()
+		time.Sleep(interval)
+	}
+}
+
+/******************************************************************************/
+
+// IndexCohorts lists the school's graduating classes, latest first.
+func IndexCohorts(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	rows, err := db.Query("SELECT grad_year, COUNT(*), SUM(archived_at IS NULL) FROM leaderboard.students "+
+		"WHERE school_id = ? AND grad_year > 0 GROUP BY grad_year ORDER BY grad_year DESC", tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	ending := schoolYearEnding(time.Now())
+	cohorts := make([]*models.Cohort, 0)
+	for rows.Next() {
+		cohort := new(models.Cohort)
+		var current int
+		if err := rows.Scan(&cohort.GradYear, &cohort.Students, &current); err != nil {
+			serverError(w, err)
+			return
+		}
+		cohort.Graduated = current == 0
+		if !cohort.Graduated {
+			cohort.GradeLevel = models.FinalGradeLevel - (cohort.GradYear - ending)
+			cohort.Board = classBoard(cohort.GradYear).Slug
+		}
+		cohorts = append(cohorts, cohort)
+	}
+	writePage(w, r, cohorts, func(i int) []interface{} { return []interface{}{cohorts[i].GradYear} }, []bool{true})
+}
+
+// IndexPromotions lists the school's promotion runs, latest first.
+func IndexPromotions(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, school_year, promoted, graduated, promoted_by, promoted_at FROM leaderboard.promotions "+
+		"WHERE school_id = ? ORDER BY id DESC", tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	promotions := make([]*models.Promotion, 0)
+	for rows.Next() {
+		promotion := new(models.Promotion)
+		var promotedAt mysql.NullTime
+		if err := rows.Scan(&promotion.ID, &promotion.SchoolYear, &promotion.Promoted, &promotion.Graduated,
+			&promotion.PromotedBy, &promotedAt); err != nil {
+			serverError(w, err)
+			return
+		}
+		promotion.PromotedAt = promotedAt.Time
+		promotions = append(promotions, promotion)
+	}
+	writePage(w, r, promotions, func(i int) []interface{} { return []interface{}{promotions[i].ID} }, []bool{true})
+}
+
+// PromoteSchool runs the annual promotion now rather than waiting for the
+// job, e.g. after correcting graduation years. Only administrators may run
+// it.
+func PromoteSchool(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	now := time.Now()
+	promotion, err := promote(db, tenant.SchoolID, schoolYearEnding(now)-1, tenant.Username, now)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, promotion)
+}
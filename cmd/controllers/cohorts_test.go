@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchoolYearEnding(t *testing.T) {
+	tests := []struct {
+		at   time.Time
+		want int
+	}{
+		{time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), 2026},
+		{time.Date(2026, time.June, 30, 23, 59, 59, 0, time.UTC), 2026},
+		{time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC), 2027},
+		{time.Date(2026, time.December, 31, 0, 0, 0, 0, time.UTC), 2027},
+	}
+	for _, tt := range tests {
+		if got := schoolYearEnding(tt.at); got != tt.want {
+			t.Errorf("schoolYearEnding(%s) = %d, want %d", tt.at.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+func TestCohortOf(t *testing.T) {
+	// Spring of the school year ending in 2026, and autumn of the next.
+	spring := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	autumn := time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name                 string
+		gradYear, gradeLevel int
+		now                  time.Time
+		wantYear, wantLevel  int
+		wantParam, wantFault string
+	}{
+		{"unknown", 0, 0, spring, 0, 0, "", ""},
+		{"senior from grade", 0, 12, spring, 2026, 12, "", ""},
+		{"freshman from grade", 0, 9, spring, 2029, 9, "", ""},
+		{"first grade from grade", 0, 1, spring, 2037, 1, "", ""},
+		{"grade after the promotion month", 0, 12, autumn, 2027, 12, "", ""},
+		{"senior from year", 2026, 0, spring, 2026, 12, "", ""},
+		{"senior from year after promotion", 2027, 0, autumn, 2027, 12, "", ""},
+		{"both agree", 2028, 10, spring, 2028, 10, "", ""},
+		{"graduate", 2025, 0, spring, 2025, 0, "", ""},
+		{"graduated this summer", 2026, 0, autumn, 2026, 0, "", ""},
+		{"furthest year", 2037, 0, spring, 2037, 1, "", ""},
+		{"too far away", 2038, 0, spring, 0, 0, "grad_year", "2038 is too far away"},
+		{"graduate with a grade", 2025, 12, spring, 0, 0, "grade_level", "12 does not graduate in 2025"},
+		{"disagree", 2028, 11, spring, 0, 0, "grade_level", "11 does not graduate in 2028"},
+		{"grade too high", 0, 13, spring, 0, 0, "grade_level", "must be between 0 and 12"},
+		{"negative grade", 2026, -1, spring, 0, 0, "grade_level", "must be between 0 and 12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			year, level, err := cohortOf(tt.gradYear, tt.gradeLevel, tt.now)
+			if tt.wantFault != "" {
+				qe, ok := err.(*QueryError)
+				if !ok || qe.Param != tt.wantParam || qe.Msg != tt.wantFault {
+					t.Fatalf("cohortOf(%d, %d) error = %v, want %s: %s", tt.gradYear, tt.gradeLevel, err, tt.wantParam, tt.wantFault)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cohortOf(%d, %d): %v", tt.gradYear, tt.gradeLevel, err)
+			}
+			if year != tt.wantYear || level != tt.wantLevel {
+				t.Errorf("cohortOf(%d, %d) = %d, %d, want %d, %d", tt.gradYear, tt.gradeLevel, year, level, tt.wantYear, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestGraduatedAt(t *testing.T) {
+	now := time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		gradYear, gradeLevel int
+		graduated            bool
+	}{
+		{0, 0, false},
+		{2026, 0, true},
+		{2026, 12, false},
+		{0, 9, false},
+	}
+	for _, tt := range tests {
+		got := graduatedAt(tt.gradYear, tt.gradeLevel, now)
+		if (got != nil) != tt.graduated || (got != nil && got != now) {
+			t.Errorf("graduatedAt(%d, %d) = %v, want graduated %v", tt.gradYear, tt.gradeLevel, got, tt.graduated)
+		}
+	}
+}
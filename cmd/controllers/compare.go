@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"leaderboard-bk/cmd/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// At most this many students or teams can be compared at once.
+const maxCompared = 10
+
+var errUnknownSubject = errors.New("unknown subject")
+
+// parseCompared parses a comma separated list of two or more distinct IDs
+// given as param.
+func parseCompared(param, s string) ([]int, error) {
+	ids := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, &QueryError{param, -1, "ids must be numeric"}
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 || len(ids) > maxCompared {
+		return nil, &QueryError{param, -1, "compare between 2 and " + strconv.Itoa(maxCompared) + " distinct ids"}
+	}
+	return ids, nil
+}
+
+// compareStudents gathers each student's scores, their rank on every board
+// the caller can view and on the stat boards they appear on, and their terms
+// oldest first.
+func compareStudents(db *sql.DB, tenant *models.Tenant, ids []int) ([]*models.StudentComparison, error) {
+	school := tenant.SchoolID
+	all, err := loadStudents(db, "school_id = ?", school)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.StudentComparison)
+	for _, stu := range all {
+		byID[stu.ID] = &models.StudentComparison{
+			Student: stu,
+			Scores:  map[string]float64{"gpa": roundTo(float64(stu.GPA), 2), "credits": float64(stu.Credits)},
+			Ranks:   make(map[string]int),
+			Terms:   make([]*models.TermRecord, 0),
+		}
+	}
+	compared := make([]*models.StudentComparison, 0, len(ids))
+	for _, id := range ids {
+		if byID[id] == nil {
+			return nil, errUnknownSubject
+		}
+		compared = append(compared, byID[id])
+	}
+	placeholders := "?" + repeatPlaceholders(len(ids)-1)
+	args := []interface{}{school}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	boards, err := schoolBoards(db, school)
+	if err != nil {
+		return nil, err
+	}
+	for _, board := range boards {
+		if !canView(tenant, board) {
+			continue
+		}
+		entries, err := computeBoard(db, school, board)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			byID[entry.Student.ID].Ranks[board.Slug] = entry.Rank
+		}
+	}
+
+	entries, err := loadStatEntries(db, "school_id = ? AND student_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	current := make([]*models.Student, 0, len(all))
+	for _, stu := range all {
+		if stu.ArchivedAt == nil {
+			current = append(current, stu)
+		}
+	}
+	recorded := make(map[int]bool)
+	for _, entry := range entries {
+		recorded[entry.StatID] = true
+	}
+	stats, err := loadStatDefinitions(db, "school_id = ?", school)
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range stats {
+		if !recorded[stat.ID] {
+			continue
+		}
+		board, err := statLeaderboard(db, stat, current, boardTieBreakers["sport_stats"])
+		if err != nil {
+			return nil, err
+		}
+		key := "stat:" + strconv.Itoa(stat.ID)
+		for _, entry := range board {
+			byID[entry.Student.ID].Scores[key] = entry.Score
+			byID[entry.Student.ID].Ranks[key] = entry.Rank
+		}
+	}
+
+	terms, err := loadTerms(db, "school_id = ? AND student_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	// loadTerms returns the most recent term first.
+	for i := len(terms) - 1; i >= 0; i-- {
+		byID[terms[i].StudentID].Terms = append(byID[terms[i].StudentID].Terms, terms[i])
+	}
+	return compared, nil
+}
+
+// compareTeams gathers each team's GPA under every aggregate, its rank among
+// the school's teams under each, and its members' mean GPA per term.
+func compareTeams(db *sql.DB, school int, ids []int) ([]*models.TeamComparison, error) {
+	teams, err := loadTeams(db, "school_id = ?", school)
+	if err != nil {
+		return nil, err
+	}
+	gpas, err := rosterGPAs(db, school, teams)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := []string{models.AggregateMean, models.AggregateMedian, models.AggregateMinimum}
+	scores := make(map[int]map[string]float64)
+	for _, team := range teams {
+		for _, aggregate := range aggregates {
+			members := gpas[team.ID][aggregate]
+			if len(members) == 0 {
+				continue
+			}
+			if scores[team.ID] == nil {
+				scores[team.ID] = make(map[string]float64)
+			}
+			scores[team.ID][aggregate] = roundTo(aggregateGPA(members, aggregate), 3)
+		}
+	}
+
+	compared := make([]*models.TeamComparison, 0, len(ids))
+	for _, id := range ids {
+		var team *models.Team
+		for _, t := range teams {
+			if t.ID == id {
+				team = t
+			}
+		}
+		if team == nil {
+			return nil, errUnknownSubject
+		}
+		c := &models.TeamComparison{Team: team, Scores: make(map[string]float64), Ranks: make(map[string]int)}
+		for aggregate, score := range scores[id] {
+			c.Scores[aggregate] = score
+			c.Ranks[aggregate] = 1
+			for _, other := range scores {
+				if theirs, ok := other[aggregate]; ok && theirs > score {
+					c.Ranks[aggregate]++
+				}
+			}
+		}
+
+		members := make([]interface{}, 0, len(team.Members)+1)
+		members = append(members, school)
+		for _, member := range team.Members {
+			members = append(members, member)
+		}
+		c.Terms = make([]*models.TermAverage, 0)
+		if len(team.Members) > 0 {
+			terms, err := loadTerms(db, "school_id = ? AND student_id IN (?"+repeatPlaceholders(len(team.Members)-1)+")", members...)
+			if err != nil {
+				return nil, err
+			}
+			byTerm := make(map[string]*models.TermAverage)
+			for _, term := range terms {
+				average, ok := byTerm[term.Term]
+				if !ok {
+					average = &models.TermAverage{Term: term.Term, EndsOn: term.EndsOn}
+					byTerm[term.Term] = average
+					c.Terms = append(c.Terms, average)
+				}
+				average.GPA += float64(term.GPA)
+				average.Members++
+			}
+			for _, average := range c.Terms {
+				average.GPA = roundTo(average.GPA/float64(average.Members), 3)
+			}
+			sort.SliceStable(c.Terms, func(i, j int) bool { return c.Terms[i].EndsOn.Before(c.Terms[j].EndsOn) })
+		}
+		compared = append(compared, c)
+	}
+	return compared, nil
+}
+
+// compareDelta subtracts the baseline's figures from a subject's.
+func compareDelta(id int, scores, baseScores map[string]float64, ranks, baseRanks map[string]int,
+	terms, baseTerms map[string]float64) *models.ComparisonDelta {
+	delta := &models.ComparisonDelta{
+		ID:     id,
+		Scores: make(map[string]float64),
+		Ranks:  make(map[string]int),
+		Terms:  make(map[string]float64),
+	}
+	for key, v := range scores {
+		if base, ok := baseScores[key]; ok {
+			delta.Scores[key] = roundTo(v-base, 3)
+		}
+	}
+	for key, v := range ranks {
+		if base, ok := baseRanks[key]; ok {
+			delta.Ranks[key] = v - base
+		}
+	}
+	for key, v := range terms {
+		if base, ok := baseTerms[key]; ok {
+			delta.Terms[key] = roundTo(v-base, 3)
+		}
+	}
+	return delta
+}
+
+/******************************************************************************/
+
+// Compare puts two or more `students` or `teams`, given as comma separated
+// IDs, side by side. The first one listed is the baseline the differences
+// are measured from.
+func Compare(w http.ResponseWriter, r *http.Request) {
+	students, teams := r.URL.Query().Get("students"), r.URL.Query().Get("teams")
+	if (students == "") == (teams == "") {
+		problem(w, http.StatusBadRequest, "give either students or teams")
+		return
+	}
+	param := "students"
+	if teams != "" {
+		param = "teams"
+	}
+	ids, err := parseCompared(param, students+teams)
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	tenant := tenantOf(r)
+	comparison := &models.Comparison{Baseline: ids[0], Differences: make([]*models.ComparisonDelta, 0)}
+	if students != "" {
+		comparison.Students, err = compareStudents(db, tenant, ids)
+		if err == nil {
+			base := comparison.Students[0]
+			baseTerms := make(map[string]float64)
+			for _, term := range base.Terms {
+				baseTerms[term.Term] = float64(term.GPA)
+			}
+			for _, c := range comparison.Students[1:] {
+				terms := make(map[string]float64)
+				for _, term := range c.Terms {
+					terms[term.Term] = float64(term.GPA)
+				}
+				comparison.Differences = append(comparison.Differences,
+					compareDelta(c.Student.ID, c.Scores, base.Scores, c.Ranks, base.Ranks, terms, baseTerms))
+			}
+		}
+	} else {
+		comparison.Teams, err = compareTeams(db, tenant.SchoolID, ids)
+		if err == nil {
+			termGPAs := func(c *models.TeamComparison) map[string]float64 {
+				gpas := make(map[string]float64)
+				for _, term := range c.Terms {
+					gpas[term.Term] = term.GPA
+				}
+				return gpas
+			}
+			base := comparison.Teams[0]
+			for _, c := range comparison.Teams[1:] {
+				comparison.Differences = append(comparison.Differences,
+					compareDelta(c.Team.ID, c.Scores, base.Scores, c.Ranks, base.Ranks, termGPAs(c), termGPAs(base)))
+			}
+		}
+	}
+	if err == errUnknownSubject {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, comparison)
+}
@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"database/sql"
+	"leaderboard-bk/cmd/models"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Histograms never have more buckets than this, however narrow the width.
+const maxBuckets = 1000
+
+var defaultPercentiles = []float64{10, 25, 50, 75, 90}
+
+// studentScores are the per-student scores that can be summarised, besides
+// the "stat:<id>" scores of each sport stat. A score reports false for
+// students it does not apply to.
+var studentScores = map[string]func(*models.Student) (float64, bool){
+	"gpa":     func(stu *models.Student) (float64, bool) { return float64(stu.GPA), true },
+	"credits": func(stu *models.Student) (float64, bool) { return float64(stu.Credits), true },
+}
+
+// scoreSource resolves a score name to the function scoring a student.
+func scoreSource(db *sql.DB, school int, name string) (func(*models.Student) (float64, bool), error) {
+	if scoreOf, ok := studentScores[name]; ok {
+		return scoreOf, nil
+	}
+	if !strings.HasPrefix(name, "stat:") {
+		return nil, &QueryError{"score", -1, "unknown score " + strconv.Quote(name)}
+	}
+	stat, err := loadStat(db, school, strings.TrimPrefix(name, "stat:"))
+	if err != nil {
+		return nil, err
+	}
+	if stat == nil {
+		return nil, &QueryError{"score", -1, "unknown score " + strconv.Quote(name)}
+	}
+	entries, err := loadStatEntries(db, "stat_id = ?", stat.ID)
+	if err != nil {
+		return nil, err
+	}
+	best := bestStats(stat, entries)
+	return func(stu *models.Student) (float64, bool) {
+		if entry, ok := best[stu.ID]; ok {
+			return roundTo(entry.Value, stat.Precision), true
+		}
+		return 0, false
+	}, nil
+}
+
+// percentile interpolates linearly between the closest ranks of the sorted
+// values, so the 50th percentile is the median.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	// Percentiles out of range, NaN included, are clamped to the ends.
+	if !(pos > 0) {
+		return sorted[0]
+	}
+	if pos >= float64(len(sorted)-1) {
+		return sorted[len(sorted)-1]
+	}
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// fixedEdges returns bucket edges of the given width covering [min, max],
+// aligned to multiples of the width. A width too narrow for maxBuckets to
+// cover the range is doubled until they do, so no value is left out.
+func fixedEdges(min, max, width float64) []float64 {
+	// Aligning the first edge may add a bucket to those the range needs.
+	for (max-min)/width > maxBuckets-2 && width < math.MaxFloat64/2 {
+		width *= 2
+	}
+	start := math.Min(math.Floor(min/width)*width, min)
+	edges := []float64{start}
+	for k := 1; k <= maxBuckets; k++ {
+		edge := start + float64(k)*width
+		edges = append(edges, edge)
+		if edge > max {
+			return edges
+		}
+	}
+	// Only reached when rounding keeps the edges short of max.
+	edges[len(edges)-1] = max
+	return edges
+}
+
+// histogram counts the values falling between consecutive edges.
+func histogram(values []float64, edges []float64) []*models.Bucket {
+	buckets := make([]*models.Bucket, 0, len(edges))
+	for i := 1; i < len(edges); i++ {
+		buckets = append(buckets, &models.Bucket{Low: edges[i-1], High: edges[i]})
+	}
+	for _, v := range values {
+		if v < edges[0] || v > edges[len(edges)-1] {
+			continue
+		}
+		i := sort.Search(len(edges), func(i int) bool { return edges[i] > v })
+		if i == len(edges) {
+			i--
+		}
+		buckets[i-1].Count++
+	}
+	return buckets
+}
+
+// describe summarises values. When edges is nil the histogram uses buckets
+// of the given width.
+func describe(values []float64, percentiles []float64, edges []float64, width float64) *models.Distribution {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	d := &models.Distribution{
+		Count:       len(sorted),
+		Percentiles: make(map[string]float64),
+		Histogram:   make([]*models.Bucket, 0),
+	}
+	if len(sorted) == 0 {
+		return d
+	}
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	d.Mean = sum / float64(len(sorted))
+	var squares float64
+	for _, v := range sorted {
+		squares += (v - d.Mean) * (v - d.Mean)
+	}
+	d.StdDev = math.Sqrt(squares / float64(len(sorted)))
+	d.Min = sorted[0]
+	d.Max = sorted[len(sorted)-1]
+	d.Median = percentile(sorted, 50)
+	for _, p := range percentiles {
+		d.Percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = percentile(sorted, p)
+	}
+	if edges == nil {
+		edges = fixedEdges(d.Min, d.Max, width)
+	}
+	d.Histogram = histogram(sorted, edges)
+	return d
+}
+
+// parseFinite parses a number, refusing NaN and the infinities that
+// strconv accepts.
+func parseFinite(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return 0, strconv.ErrSyntax
+	}
+	return v, err
+}
+
+// parseFloats parses a comma separated list of numbers given as param.
+func parseFloats(param, s string) ([]float64, error) {
+	values := make([]float64, 0)
+	for _, part := range strings.Split(s, ",") {
+		v, err := parseFinite(strings.TrimSpace(part))
+		if err != nil {
+			return nil, &QueryError{param, -1, strconv.Quote(part) + " is not a number"}
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// histogramParams reads `edges` (custom bucket boundaries) or `width` (fixed
+// bucket width, 0.5 by default) and `percentiles` from the query.
+func histogramParams(r *http.Request) (edges []float64, width float64, percentiles []float64, err error) {
+	width = 0.5
+	percentiles = defaultPercentiles
+	q := r.URL.Query()
+	if v := q.Get("edges"); v != "" {
+		if edges, err = parseFloats("edges", v); err != nil {
+			return nil, 0, nil, err
+		}
+		if len(edges) < 2 || len(edges) > maxBuckets+1 || !sort.Float64sAreSorted(edges) {
+			return nil, 0, nil, &QueryError{"edges", -1, "must be at least two ascending numbers"}
+		}
+	}
+	if v := q.Get("width"); v != "" {
+		if width, err = parseFinite(v); err != nil || width <= 0 {
+			return nil, 0, nil, &QueryError{"width", -1, "must be a positive number"}
+		}
+	}
+	if v := q.Get("percentiles"); v != "" {
+		if percentiles, err = parseFloats("percentiles", v); err != nil {
+			return nil, 0, nil, err
+		}
+		for _, p := range percentiles {
+			if p < 0 || p > 100 {
+				return nil, 0, nil, &QueryError{"percentiles", -1, "must be between 0 and 100"}
+			}
+		}
+	}
+	return edges, width, percentiles, nil
+}
+
+/******************************************************************************/
+
+// Distribution summarises a score (`score`, gpa by default) across the
+// school, optionally grouped by sport, team or term (`group`). Naming a
+// `board` instead summarises the scores of the students on that board.
+func Distribution(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	edges, width, percentiles, err := histogramParams(r)
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	report := &models.DistributionReport{Score: r.URL.Query().Get("score"), GroupBy: r.URL.Query().Get("group")}
+	if report.Score == "" {
+		report.Score = "gpa"
+	}
+	school := tenantOf(r).SchoolID
+	stus, err := loadStudents(db, currentStudents, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if slug := r.URL.Query().Get("board"); slug != "" {
+		board, err := resolveBoard(db, school, slug)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if board == nil || !canView(tenantOf(r), board) {
+			invalidField(w, "board", "unknown board "+strconv.Quote(slug))
+			return
+		}
+		report.Score = board.Score
+		if stus, err = filterStudents(db, school, board.Filter, stus); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	scoreOf, err := scoreSource(db, school, report.Score)
+	if err != nil {
+		requestError(w, err)
+		return
+	}
+	overall := make([]float64, 0, len(stus))
+	for _, stu := range stus {
+		if v, ok := scoreOf(stu); ok {
+			overall = append(overall, v)
+		}
+	}
+
+	groups := make(map[string][]float64)
+	teamOf := make(map[string]*models.Team)
+	switch report.GroupBy {
+	case "":
+	case "sport":
+		for _, stu := range stus {
+			if v, ok := scoreOf(stu); ok {
+				groups[stu.Sport] = append(groups[stu.Sport], v)
+			}
+		}
+	case "team":
+		teams, err := loadTeams(db, "school_id = ?", school)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		byID := make(map[int]*models.Student)
+		for _, stu := range stus {
+			byID[stu.ID] = stu
+		}
+		for _, team := range teams {
+			// Teams are grouped by ID, as several sports may each have a
+			// team of the same name and level, and labelled with the sport.
+			key := team.Name + " (" + team.Sport + ", " + team.Level + ")\x00" + strconv.Itoa(team.ID)
+			teamOf[key] = team
+			groups[key] = make([]float64, 0)
+			for _, id := range team.Members {
+				if stu, ok := byID[id]; ok {
+					if v, ok := scoreOf(stu); ok {
+						groups[key] = append(groups[key], v)
+					}
+				}
+			}
+		}
+	case "term":
+		// Term grouping summarises the results recorded for each term
+		// rather than the students' current standing.
+		if report.Score != "gpa" && report.Score != "credits" {
+			invalidField(w, "score", "only gpa and credits can be grouped by term")
+			return
+		}
+		terms, err := loadTerms(db, "school_id = ?", school)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		overall = overall[:0]
+		for _, term := range terms {
+			v := float64(term.GPA)
+			if report.Score == "credits" {
+				v = float64(term.Credits)
+			}
+			overall = append(overall, v)
+			groups[term.Term] = append(groups[term.Term], v)
+		}
+	default:
+		invalidField(w, "group", "must be one of sport, team or term")
+		return
+	}
+
+	report.Overall = describe(overall, percentiles, edges, width)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d := describe(groups[name], percentiles, edges, width)
+		d.Group = name
+		if team := teamOf[name]; team != nil {
+			d.Group, d.TeamID = strings.SplitN(name, "\x00", 2)[0], team.ID
+		}
+		report.Groups = append(report.Groups, d)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
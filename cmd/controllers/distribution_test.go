@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFixedEdges(t *testing.T) {
+	tests := []struct {
+		name          string
+		min, max      float64
+		width         float64
+		first, last   float64
+		buckets       int
+		widthOfBucket float64
+	}{
+		{"aligned to the width", 1.2, 3.9, 0.5, 1, 4, 6, 0.5},
+		{"max on an edge", 2, 4, 0.5, 2, 4.5, 5, 0.5},
+		{"single value", 3, 3, 0.5, 3, 3.5, 1, 0.5},
+		{"negative values", -1.2, 0.3, 0.5, -1.5, 0.5, 4, 0.5},
+		{"widened to cover the range", 0, 100, 0.01, 0, 100.16, 626, 0.16},
+		{"widened for a wide range", 0, 1e9, 1, 0, 1000341504, 954, 1048576},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edges := fixedEdges(tt.min, tt.max, tt.width)
+			buckets := len(edges) - 1
+			if buckets != tt.buckets || buckets > maxBuckets {
+				t.Errorf("fixedEdges(%v, %v, %v) has %d buckets, want %d", tt.min, tt.max, tt.width, buckets, tt.buckets)
+			}
+			if math.Abs(edges[0]-tt.first) > 1e-9 || math.Abs(edges[buckets]-tt.last) > 1e-6 {
+				t.Errorf("fixedEdges(%v, %v, %v) spans [%v, %v], want [%v, %v]",
+					tt.min, tt.max, tt.width, edges[0], edges[buckets], tt.first, tt.last)
+			}
+			if got := edges[1] - edges[0]; math.Abs(got-tt.widthOfBucket) > 1e-9 {
+				t.Errorf("fixedEdges(%v, %v, %v) buckets are %v wide, want %v", tt.min, tt.max, tt.width, got, tt.widthOfBucket)
+			}
+		})
+	}
+}
+
+// However narrow the width, every value is counted.
+func TestDescribeCountsEveryValue(t *testing.T) {
+	values := []float64{0, 0.5, 2, 3.25, 3.99, 4, 250, 999.99}
+	for _, width := range []float64{0.001, 0.5, 1000} {
+		d := describe(values, nil, nil, width)
+		total := 0
+		for _, b := range d.Histogram {
+			total += b.Count
+		}
+		if total != len(values) || len(d.Histogram) > maxBuckets {
+			t.Errorf("width %v: %d buckets count %d values, want %d", width, len(d.Histogram), total, len(values))
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	got := histogram([]float64{0.5, 1, 1.5, 2, 2.5, 3, 3.5}, []float64{1, 2, 3})
+	counts := make([]int, 0, len(got))
+	for _, b := range got {
+		counts = append(counts, b.Count)
+	}
+	// Values outside custom edges are left out; the last bucket includes
+	// its upper bound.
+	if want := []int{2, 3}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("histogram counts = %v, want %v", counts, want)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4}
+	tests := []struct {
+		p, want float64
+	}{
+		{0, 1}, {50, 2.5}, {100, 4}, {25, 1.75}, {-10, 1}, {150, 4}, {math.NaN(), 1},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
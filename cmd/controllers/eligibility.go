@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// eligibilityEvaluator holds everything needed to evaluate the students of
+// one school (or, for district boards, every school) without going back to
+// the database per student.
+type eligibilityEvaluator struct {
+	rules  map[int][]*models.EligibilityRule
+	terms  map[int][]*models.TermRecord
+	levels map[int]string
+}
+
+// loadEligibility loads the rules, term history and team levels for school.
+// A school of 0 loads every school.
+func loadEligibility(db *sql.DB, school int) (*eligibilityEvaluator, error) {
+	ev := &eligibilityEvaluator{
+		rules:  make(map[int][]*models.EligibilityRule),
+		levels: make(map[int]string),
+	}
+
+	rows, err := db.Query("SELECT id, school_id, sport, level, min_gpa, min_credits, grace_terms "+
+		"FROM leaderboard.eligibility_rules WHERE school_id = ? OR ? = 0", school, school)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rule := new(models.EligibilityRule)
+		var schoolID int
+		if err := rows.Scan(&rule.ID, &schoolID, &rule.Sport, &rule.Level, &rule.MinGPA, &rule.MinCredits, &rule.GraceTerms); err != nil {
+			return nil, err
+		}
+		ev.rules[schoolID] = append(ev.rules[schoolID], rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	terms, err := loadTerms(db, "school_id = ? OR ? = 0", school, school)
+	if err != nil {
+		return nil, err
+	}
+	ev.terms = termsByStudent(terms)
+
+	// A student's level is that of the team they play on for their own sport.
+	levels, err := db.Query("SELECT tm.student_id, t.level FROM leaderboard.team_members tm "+
+		"JOIN leaderboard.teams t ON t.id = tm.team_id JOIN leaderboard.students s ON s.id = tm.student_id "+
+		"WHERE t.sport = s.sport AND (t.school_id = ? OR ? = 0) ORDER BY t.id DESC", school, school)
+	if err != nil {
+		return nil, err
+	}
+	defer levels.Close()
+	for levels.Next() {
+		var studentID int
+		var level string
+		if err := levels.Scan(&studentID, &level); err != nil {
+			return nil, err
+		}
+		ev.levels[studentID] = level
+	}
+	return ev, levels.Err()
+}
+
+// ruleFor picks the most specific rule for a sport and level: an exact level
+// match wins over a rule that covers the whole sport.
+func (ev *eligibilityEvaluator) ruleFor(school int, sport string, level string) *models.EligibilityRule {
+	var match *models.EligibilityRule
+	for _, rule := range ev.rules[school] {
+		if !strings.EqualFold(rule.Sport, sport) {
+			continue
+		}
+		if rule.Level == level {
+			return rule
+		}
+		if rule.Level == "" {
+			match = rule
+		}
+	}
+	return match
+}
+
+// evaluate returns the student's eligibility at the given level, or at the
+// level of their team when level is empty. Students in a sport without a rule
+// are not subject to eligibility and get nil.
+//
+// A student below a minimum is on probation while the number of consecutive
+// most recent terms below the GPA minimum (counting the current standing as at
+// least one) is within the rule's grace period, and ineligible after that.
+func (ev *eligibilityEvaluator) evaluate(stu *models.Student, level string) *models.Eligibility {
+	if level == "" {
+		level = ev.levels[stu.ID]
+	}
+	rule := ev.ruleFor(stu.SchoolID, stu.Sport, level)
+	if rule == nil {
+		return nil
+	}
+
+	result := &models.Eligibility{Status: models.EligibilityEligible, RuleID: rule.ID, Level: level}
+	if stu.GPA < rule.MinGPA {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("gpa %.2f is below the minimum of %.2f", stu.GPA, rule.MinGPA))
+	}
+	if stu.Credits < rule.MinCredits {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("credits %.1f are below the minimum of %.1f", stu.Credits, rule.MinCredits))
+	}
+	if len(result.Reasons) == 0 {
+		return result
+	}
+
+	termsBelow := 0
+	for _, term := range ev.terms[stu.ID] {
+		if term.GPA >= rule.MinGPA {
+			break
+		}
+		termsBelow++
+	}
+	if termsBelow == 0 {
+		termsBelow = 1
+	}
+	if termsBelow <= rule.GraceTerms {
+		result.Status = models.EligibilityProbation
+		result.Reasons = append(result.Reasons, fmt.Sprintf("grace term %d of %d", termsBelow, rule.GraceTerms))
+	} else {
+		result.Status = models.EligibilityIneligible
+	}
+	return result
+}
+
+/******************************************************************************/
+
+func IndexEligibilityRules(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	ev, err := loadEligibility(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	rules := ev.rules[tenantOf(r).SchoolID]
+	if rules == nil {
+		rules = make([]*models.EligibilityRule, 0)
+	}
+	writePage(w, r, rules, func(i int) []interface{} { return []interface{}{rules[i].ID} }, []bool{true})
+}
+
+// InsertEligibilityRule creates the rule for a sport and level, replacing any
+// existing rule for the same pair.
+func InsertEligibilityRule(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var rule models.EligibilityRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		invalid(w, err)
+		return
+	}
+	rule.Level = strings.ToLower(rule.Level)
+	if rule.Sport == "" {
+		invalidField(w, "sport", "is required")
+		return
+	}
+	if rule.Level != "" && !teamLevels[rule.Level] {
+		invalidField(w, "level", "unknown level "+rule.Level)
+		return
+	}
+	faults := make([]*models.FieldError, 0)
+	negative := func(field string) {
+		faults = append(faults, &models.FieldError{Field: field, Detail: "must not be negative"})
+	}
+	if rule.MinGPA < 0 {
+		negative("min_gpa")
+	}
+	if rule.MinCredits < 0 {
+		negative("min_credits")
+	}
+	if rule.GraceTerms < 0 {
+		negative("grace_terms")
+	}
+	if len(faults) > 0 {
+		invalidFields(w, faults...)
+		return
+	}
+
+	res, err := db.Exec("INSERT INTO leaderboard.eligibility_rules(school_id, sport, level, min_gpa, min_credits, grace_terms) "+
+		"VALUES(?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), "+
+		"min_gpa = VALUES(min_gpa), min_credits = VALUES(min_credits), grace_terms = VALUES(grace_terms)",
+		tenantOf(r).SchoolID, rule.Sport, rule.Level, rule.MinGPA, rule.MinCredits, rule.GraceTerms)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	rule.ID = int(id)
+	boardsChanged(tenantOf(r).SchoolID)
+	log.Println("INSERT ELIGIBILITY RULE: Sport: " + rule.Sport + " | Level: " + rule.Level)
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func DeleteEligibilityRule(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM leaderboard.eligibility_rules WHERE id = ? AND school_id = ?",
+		mux.Vars(r)["ruleId"], tenantOf(r).SchoolID); err != nil {
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenantOf(r).SchoolID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************/
+
+// EligibilityReport lists every student subject to a rule, worst status
+// first. It can be narrowed with the `sport`, `level` and `status` query
+// parameters.
+func EligibilityReport(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	school := tenantOf(r).SchoolID
+	ev, err := loadEligibility(db, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	where := currentStudents
+	args := []interface{}{school}
+	if sport := r.URL.Query().Get("sport"); sport != "" {
+		where += " AND sport = ?"
+		args = append(args, sport)
+	}
+	stus, err := loadStudents(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	level := strings.ToLower(r.URL.Query().Get("level"))
+	status := r.URL.Query().Get("status")
+	report := make([]*models.EligibilityReportEntry, 0)
+	for _, stu := range stus {
+		result := ev.evaluate(stu, "")
+		if result == nil || (level != "" && result.Level != level) || (status != "" && result.Status != status) {
+			continue
+		}
+		report = append(report, &models.EligibilityReportEntry{Student: stu, Eligibility: result})
+	}
+
+	severity := map[string]int{
+		models.EligibilityIneligible: 0,
+		models.EligibilityProbation:  1,
+		models.EligibilityEligible:   2,
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Eligibility.Status != b.Eligibility.Status {
+			return severity[a.Eligibility.Status] < severity[b.Eligibility.Status]
+		}
+		if la, lb := strings.ToLower(a.Student.LastName), strings.ToLower(b.Student.LastName); la != lb {
+			return la < lb
+		}
+		return a.Student.ID < b.Student.ID
+	})
+	writePage(w, r, report, func(i int) []interface{} {
+		entry := report[i]
+		return []interface{}{severity[entry.Eligibility.Status], strings.ToLower(entry.Student.LastName), entry.Student.ID}
+	}, []bool{false, false, false})
+}
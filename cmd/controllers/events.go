@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"database/sql"
+	"log"
+)
+
+// studentChanged is called after any write to a student or their terms,
+// including deleting the student, so that everything derived from student
+// data is brought up to date.
+func studentChanged(db *sql.DB, school int, studentID int) {
+	invalidateNameIndex(school)
+	boardsChanged(school)
+	if err := checkStudentRecords(db, school, studentID); err != nil {
+		log.Println("RECORDS: " + err.Error())
+	}
+	if err := evaluateBadges(db, school, studentID); err != nil {
+		log.Println("BADGES: " + err.Error())
+	}
+}
+
+// touchStudent marks a student as updated after a write to their terms or
+// stats, for the "updated" tie-breaker. The student's own writes set
+// updated_at themselves.
+func touchStudent(db *sql.DB, school int, studentID int) {
+	if _, err := db.Exec("UPDATE leaderboard.students SET updated_at = NOW() WHERE id = ? AND school_id = ?",
+		studentID, school); err != nil {
+		log.Println("TOUCH STUDENT: " + err.Error())
+	}
+}
@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// A term counts towards the term GPA record only on a full course load.
+const recordMinCredits = 12
+
+// Season archives keep this many places unless the request says otherwise.
+const defaultSeasonTop = 3
+
+// Season names are at most this long, as the column is.
+const maxSeasonLength = 64
+
+var recordNames = map[string]string{
+	models.RecordTermGPA:         "Highest term GPA",
+	models.RecordHonorRollStreak: "Longest honor roll streak",
+}
+
+// loadRecords returns the records matching the where clause, newest first,
+// named after what they measure.
+func loadRecords(db *sql.DB, school int, where string, args ...interface{}) ([]*models.Record, error) {
+	rows, err := db.Query("SELECT r.id, r.record_key, r.student_id, r.firstName, r.lastName, r.value, r.detail, r.achieved_on, r.set_at "+
+		"FROM leaderboard.records r WHERE r.school_id = ? AND "+where+" ORDER BY r.id DESC",
+		append([]interface{}{school}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*models.Record, 0)
+	for rows.Next() {
+		record := new(models.Record)
+		var achievedOn, setAt mysql.NullTime
+		if err := rows.Scan(&record.ID, &record.Key, &record.StudentID, &record.FirstName, &record.LastName,
+			&record.Value, &record.Detail, &achievedOn, &setAt); err != nil {
+			return nil, err
+		}
+		record.AchievedOn, record.SetAt = achievedOn.Time, setAt.Time
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats, err := loadStatDefinitions(db, "school_id = ?", school)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		record.Name = recordNames[record.Key]
+		for _, stat := range stats {
+			if record.Key == "stat:"+strconv.Itoa(stat.ID) {
+				record.Name = "Best " + stat.Name + " (" + stat.Sport + ")"
+			}
+		}
+	}
+	return records, nil
+}
+
+// standingRecords returns the record currently standing for each key.
+func standingRecords(db *sql.DB, school int) ([]*models.Record, error) {
+	return loadRecords(db, school, "r.id IN (SELECT MAX(id) FROM leaderboard.records WHERE school_id = ? GROUP BY record_key)", school)
+}
+
+// claimRecord stores the candidate as the new record for its key when it
+// beats the standing one. Equalling a record does not take it.
+func claimRecord(db *sql.DB, school int, candidate *models.Record, lowerIsBetter bool) error {
+	standing, err := loadRecords(db, school,
+		"r.id = (SELECT MAX(id) FROM leaderboard.records WHERE school_id = ? AND record_key = ?)", school, candidate.Key)
+	if err != nil {
+		return err
+	}
+	if len(standing) > 0 {
+		current := standing[0].Value
+		if (lowerIsBetter && candidate.Value >= current) || (!lowerIsBetter && candidate.Value <= current) {
+			return nil
+		}
+	}
+	if _, err := db.Exec("INSERT INTO leaderboard.records(school_id, record_key, student_id, firstName, lastName, value, detail, achieved_on) "+
+		"VALUES(?, ?, ?, ?, ?, ?, ?, ?)", school, candidate.Key, candidate.StudentID, candidate.FirstName, candidate.LastName,
+		candidate.Value, candidate.Detail, candidate.AchievedOn); err != nil {
+		return err
+	}
+	log.Println("RECORD: " + candidate.Key + " | Student: " + strconv.Itoa(candidate.StudentID) +
+		" | Value: " + strconv.FormatFloat(candidate.Value, 'f', -1, 64))
+	return nil
+}
+
+// checkStudentRecords looks for term GPA and honor roll streak records in a
+// student's terms. Like badges, records are never taken back when a term is
+// corrected later.
+func checkStudentRecords(db *sql.DB, school int, studentID int) error {
+	stus, err := loadStudents(db, "id = ? AND school_id = ?", studentID, school)
+	if err != nil || len(stus) == 0 {
+		return err
+	}
+	stu := stus[0]
+	terms, err := loadTerms(db, "student_id = ? AND school_id = ?", studentID, school)
+	if err != nil {
+		return err
+	}
+	// loadTerms returns the most recent term first.
+	for i, j := 0, len(terms)-1; i < j; i, j = i+1, j-1 {
+		terms[i], terms[j] = terms[j], terms[i]
+	}
+
+	honorRoll := models.BadgeRule{MinGPA: 3.0}
+	badges, err := loadBadges(db, school)
+	if err != nil {
+		return err
+	}
+	for _, badge := range badges {
+		if badge.Key == "honor_roll_streak" {
+			honorRoll = badge.Rule
+		}
+	}
+
+	var bestTerm, streakEnd *models.TermRecord
+	streak, longest := 0, 0
+	for _, term := range terms {
+		if term.Credits >= recordMinCredits && (bestTerm == nil || term.GPA > bestTerm.GPA) {
+			bestTerm = term
+		}
+		if term.GPA >= honorRoll.MinGPA && term.Credits >= honorRoll.MinCredits {
+			streak++
+		} else {
+			streak = 0
+		}
+		if streak > longest {
+			longest, streakEnd = streak, term
+		}
+	}
+
+	record := func(key string, value float64, term *models.TermRecord) *models.Record {
+		return &models.Record{Key: key, StudentID: stu.ID, FirstName: stu.FirstName, LastName: stu.LastName,
+			Value: roundTo(value, 2), Detail: term.Term, AchievedOn: term.EndsOn}
+	}
+	if bestTerm != nil {
+		if err := claimRecord(db, school, record(models.RecordTermGPA, float64(bestTerm.GPA), bestTerm), false); err != nil {
+			return err
+		}
+	}
+	if streakEnd != nil {
+		return claimRecord(db, school, record(models.RecordHonorRollStreak, float64(longest), streakEnd), false)
+	}
+	return nil
+}
+
+// checkStatRecord sees whether a newly recorded stat entry is a record.
+func checkStatRecord(db *sql.DB, school int, stat *models.StatDefinition, entry *models.StatEntry) error {
+	stus, err := loadStudents(db, "id = ? AND school_id = ?", entry.StudentID, school)
+	if err != nil || len(stus) == 0 {
+		return err
+	}
+	return claimRecord(db, school, &models.Record{
+		Key:        "stat:" + strconv.Itoa(stat.ID),
+		StudentID:  entry.StudentID,
+		FirstName:  stus[0].FirstName,
+		LastName:   stus[0].LastName,
+		Value:      roundTo(entry.Value, stat.Precision),
+		Detail:     stat.Sport,
+		AchievedOn: entry.RecordedAt,
+	}, stat.LowerIsBetter)
+}
+
+// loadSeasonWinners returns the archived winners matching the where clause,
+// latest season first, then by board and rank.
+func loadSeasonWinners(db *sql.DB, where string, args ...interface{}) ([]*models.SeasonWinner, error) {
+	rows, err := db.Query("SELECT id, season, board, rank_position, student_id, firstName, lastName, score, archived_at "+
+		"FROM leaderboard.season_winners WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	winners := make([]*models.SeasonWinner, 0)
+	for rows.Next() {
+		winner := new(models.SeasonWinner)
+		var archivedAt mysql.NullTime
+		if err := rows.Scan(&winner.ID, &winner.Season, &winner.Board, &winner.Rank, &winner.StudentID,
+			&winner.FirstName, &winner.LastName, &winner.Score, &archivedAt); err != nil {
+			return nil, err
+		}
+		winner.ArchivedAt = archivedAt.Time
+		winners = append(winners, winner)
+	}
+	sort.Slice(winners, func(i, j int) bool {
+		return compareKeys(seasonWinnerKey(winners[i]), seasonWinnerKey(winners[j]), seasonWinnerOrder) < 0
+	})
+	return winners, rows.Err()
+}
+
+var seasonWinnerOrder = []bool{true, false, false, false}
+
+func seasonWinnerKey(winner *models.SeasonWinner) []interface{} {
+	return []interface{}{strings.ToLower(winner.Season), winner.Board, winner.Rank, winner.StudentID}
+}
+
+/******************************************************************************/
+
+// IndexRecords lists the standing all-time records.
+func IndexRecords(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	records, err := standingRecords(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	writePage(w, r, records, func(i int) []interface{} { return []interface{}{records[i].Key} }, []bool{false})
+}
+
+// RecordHistory lists every time a record was broken, latest first.
+func RecordHistory(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	records, err := loadRecords(db, tenantOf(r).SchoolID, "r.record_key = ?", mux.Vars(r)["recordKey"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, records, func(i int) []interface{} { return []interface{}{records[i].ID} }, []bool{true})
+}
+
+// IndexSeasonWinners lists archived season winners, optionally for one
+// `season` or `board`.
+func IndexSeasonWinners(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	where := "school_id = ?"
+	args := []interface{}{tenantOf(r).SchoolID}
+	for _, param := range []string{"season", "board"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			where += " AND " + param + " = ?"
+			args = append(args, v)
+		}
+	}
+	winners, err := loadSeasonWinners(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, winners, func(i int) []interface{} { return seasonWinnerKey(winners[i]) }, seasonWinnerOrder)
+}
+
+// ArchiveSeason copies the current leaders of the school's boards into the
+// hall of fame. Only administrators may archive a season, and each board
+// can be archived once per season.
+func ArchiveSeason(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	var archive models.SeasonArchive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil {
+		invalid(w, err)
+		return
+	}
+	archive.Season = strings.TrimSpace(archive.Season)
+	if archive.Season == "" {
+		invalidField(w, "season", "is required")
+		return
+	}
+	if utf8.RuneCountInString(archive.Season) > maxSeasonLength {
+		invalidField(w, "season", "must be at most "+strconv.Itoa(maxSeasonLength)+" characters")
+		return
+	}
+	if archive.Top == 0 {
+		archive.Top = defaultSeasonTop
+	}
+	if archive.Top < 0 {
+		invalidField(w, "top", "must be positive")
+		return
+	}
+
+	boards := make([]*models.Leaderboard, 0)
+	if len(archive.Boards) == 0 {
+		all, err := schoolBoards(db, tenant.SchoolID)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		boards = all
+	}
+	seen := make(map[string]bool)
+	for _, slug := range archive.Boards {
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		board, err := resolveBoard(db, tenant.SchoolID, slug)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if board == nil {
+			invalidField(w, "board", "unknown board "+strconv.Quote(slug))
+			return
+		}
+		boards = append(boards, board)
+	}
+
+	standings := make([][]*models.LeaderboardEntry, 0, len(boards))
+	for _, board := range boards {
+		entries, err := computeBoard(db, tenant.SchoolID, board)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		standings = append(standings, entries)
+	}
+
+	// Every board is archived or none is; the unique key settles a race
+	// with another archive of the same season.
+	tx, err := db.Begin()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	alreadyArchived := func(board string) {
+		problem(w, http.StatusConflict, "season "+strconv.Quote(archive.Season)+" is already archived for board "+strconv.Quote(board))
+	}
+	archivedAt := time.Now()
+	for i, board := range boards {
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM leaderboard.season_winners WHERE school_id = ? AND season = ? AND board = ?",
+			tenant.SchoolID, archive.Season, board.Slug).Scan(&n); err != nil {
+			serverError(w, err)
+			return
+		}
+		if n > 0 {
+			alreadyArchived(board.Slug)
+			return
+		}
+		for _, entry := range standings[i] {
+			if entry.Rank > archive.Top {
+				break
+			}
+			if _, err := tx.Exec("INSERT INTO leaderboard.season_winners(school_id, season, board, rank_position, student_id, "+
+				"firstName, lastName, score, archived_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)", tenant.SchoolID, archive.Season,
+				board.Slug, entry.Rank, entry.Student.ID, entry.Student.FirstName, entry.Student.LastName, entry.Score,
+				archivedAt); err != nil {
+				if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+					alreadyArchived(board.Slug)
+					return
+				}
+				serverError(w, err)
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		serverError(w, err)
+		return
+	}
+	log.Println("ARCHIVE SEASON: " + archive.Season + " | By: " + tenant.Username)
+
+	winners, err := loadSeasonWinners(db, "school_id = ? AND season = ?", tenant.SchoolID, archive.Season)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, winners)
+}
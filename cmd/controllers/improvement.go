@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Students need at least this many credits in both periods before their
+// improvement counts, unless the request sets `min_credits`.
+const defaultImprovementMinCredits = 6
+
+// standing is a student's GPA and credits at one point in time.
+type standing struct {
+	GPA     float64
+	Credits float64
+}
+
+// loadTermStandings returns every student's standing for a term.
+func loadTermStandings(db *sql.DB, school int, term string) (map[int]standing, error) {
+	return queryStandings(db, "SELECT student_id, gpa, credits FROM leaderboard.student_terms WHERE school_id = ? AND term = ?",
+		school, term)
+}
+
+// loadSnapshotStandings returns every student's standing in a snapshot.
+func loadSnapshotStandings(db *sql.DB, school int, snapshot string) (map[int]standing, error) {
+	return queryStandings(db, "SELECT e.student_id, e.gpa, e.credits FROM leaderboard.snapshot_entries e "+
+		"JOIN leaderboard.snapshots s ON s.id = e.snapshot_id WHERE s.school_id = ? AND s.id = ?", school, snapshot)
+}
+
+func queryStandings(db *sql.DB, query string, args ...interface{}) (map[int]standing, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	standings := make(map[int]standing)
+	for rows.Next() {
+		var id int
+		var s standing
+		if err := rows.Scan(&id, &s.GPA, &s.Credits); err != nil {
+			return nil, err
+		}
+		standings[id] = s
+	}
+	return standings, rows.Err()
+}
+
+// improvementEntries scores every student present in both periods by how
+// much their GPA rose. Relative change is measured against the starting GPA,
+// so students starting from zero are left out of relative boards.
+func improvementEntries(stus []*models.Student, from, to map[int]standing, relative bool, minCredits float64, chain []string) []*models.LeaderboardEntry {
+	entries := make([]*models.LeaderboardEntry, 0)
+	for _, stu := range stus {
+		before, ok := from[stu.ID]
+		if !ok {
+			continue
+		}
+		after, ok := to[stu.ID]
+		if !ok {
+			continue
+		}
+		if before.Credits < minCredits || after.Credits < minCredits {
+			continue
+		}
+		if relative && before.GPA <= 0 {
+			continue
+		}
+
+		change := after.GPA - before.GPA
+		details := map[string]float64{
+			"from":            before.GPA,
+			"to":              after.GPA,
+			"absolute_change": change,
+		}
+		score := change
+		if before.GPA > 0 {
+			details["relative_change"] = change / before.GPA
+		}
+		if relative {
+			score = details["relative_change"]
+		}
+		entries = append(entries, &models.LeaderboardEntry{Student: stu, Score: score, Details: details})
+	}
+	return rankEntries(entries, false, chain)
+}
+
+// loadStandingsParam resolves the `<name>` (term) or `<name>_snapshot`
+// query parameter to a set of standings.
+func loadStandingsParam(db *sql.DB, r *http.Request, name string) (map[int]standing, bool, error) {
+	school := tenantOf(r).SchoolID
+	if term := r.URL.Query().Get(name); term != "" {
+		standings, err := loadTermStandings(db, school, term)
+		return standings, true, err
+	}
+	if snapshot := r.URL.Query().Get(name + "_snapshot"); snapshot != "" {
+		standings, err := loadSnapshotStandings(db, school, snapshot)
+		return standings, true, err
+	}
+	return nil, false, nil
+}
+
+/******************************************************************************/
+
+// ImprovementLeaderboard ranks students by GPA improvement between two terms
+// (`from` and `to`) or two snapshots (`from_snapshot` and `to_snapshot`).
+// `mode` is absolute (default) or relative, and `min_credits` guards
+// against tiny course loads dominating the board.
+func ImprovementLeaderboard(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	relative := false
+	switch r.URL.Query().Get("mode") {
+	case "", "absolute":
+	case "relative":
+		relative = true
+	default:
+		invalidField(w, "mode", "must be absolute or relative")
+		return
+	}
+	chain, err := tieBreakerChain(r, "improvement")
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	minCredits := float64(defaultImprovementMinCredits)
+	if v := r.URL.Query().Get("min_credits"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			invalidField(w, "min_credits", "must be a non-negative number")
+			return
+		}
+		minCredits = n
+	}
+
+	from, ok, err := loadStandingsParam(db, r, "from")
+	if err == nil && !ok {
+		invalidField(w, "from", "is required, or from_snapshot")
+		return
+	}
+	var to map[int]standing
+	if err == nil {
+		to, ok, err = loadStandingsParam(db, r, "to")
+		if err == nil && !ok {
+			invalidField(w, "to", "is required, or to_snapshot")
+			return
+		}
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	stus, err := loadStudents(db, currentStudents, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeEntryPage(w, r, improvementEntries(stus, from, to, relative, minCredits, chain), false, chain)
+}
+
+/******************************************************************************/
+
+func IndexSnapshots(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	rows, err := db.Query("SELECT s.id, s.label, s.taken_at, COUNT(e.student_id) FROM leaderboard.snapshots s "+
+		"LEFT JOIN leaderboard.snapshot_entries e ON e.snapshot_id = s.id WHERE s.school_id = ? "+
+		"GROUP BY s.id, s.label, s.taken_at ORDER BY s.taken_at DESC, s.id DESC", tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	snapshots := make([]*models.Snapshot, 0)
+	for rows.Next() {
+		snapshot := new(models.Snapshot)
+		var takenAt mysql.NullTime
+		if err := rows.Scan(&snapshot.ID, &snapshot.Label, &takenAt, &snapshot.Students); err != nil {
+			serverError(w, err)
+			return
+		}
+		snapshot.TakenAt = takenAt.Time
+		snapshots = append(snapshots, snapshot)
+	}
+	writePage(w, r, snapshots, func(i int) []interface{} {
+		return []interface{}{micros(snapshots[i].TakenAt), snapshots[i].ID}
+	}, []bool{true, true})
+}
+
+// InsertSnapshot copies the current GPA and credits of every student in the
+// school into a new snapshot.
+func InsertSnapshot(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var snapshot models.Snapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		invalid(w, err)
+		return
+	}
+	if snapshot.Label == "" {
+		invalidField(w, "label", "is required")
+		return
+	}
+
+	school := tenantOf(r).SchoolID
+	res, err := db.Exec("INSERT INTO leaderboard.snapshots(school_id, label) VALUES(?, ?)", school, snapshot.Label)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	snapshot.ID = int(id)
+	res, err = db.Exec("INSERT INTO leaderboard.snapshot_entries(snapshot_id, student_id, gpa, credits) "+
+		"SELECT ?, id, gpa, credits FROM leaderboard.students WHERE school_id = ?", snapshot.ID, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	n, _ := res.RowsAffected()
+	snapshot.Students = int(n)
+	if err := snapshotRanks(db, school, snapshot.ID); err != nil {
+		log.Println("RANK HISTORY: " + err.Error())
+	}
+	var takenAt mysql.NullTime
+	if err := db.QueryRow("SELECT taken_at FROM leaderboard.snapshots WHERE id = ?", snapshot.ID).Scan(&takenAt); err == nil {
+		snapshot.TakenAt = takenAt.Time
+	}
+	log.Println("SNAPSHOT: " + snapshot.Label)
+	writeJSON(w, http.StatusCreated, snapshot)
+}
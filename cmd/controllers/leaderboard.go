@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"database/sql"
+	"leaderboard-bk/cmd/models"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const studentColumns = "id, school_id, firstName, lastName, gpa, credits, sport, grad_year, grade_level, archived_at, t_stamp, updated_at"
+
+// currentStudents selects the school's students who have not graduated.
+const currentStudents = "school_id = ? AND archived_at IS NULL"
+
+// scanStudent reads one row selected with studentColumns.
+func scanStudent(rows *sql.Rows) (*models.Student, error) {
+	stu := new(models.Student)
+	var archived, stamp, updated mysql.NullTime
+	err := rows.Scan(&stu.ID,
+		&stu.SchoolID,
+		&stu.FirstName,
+		&stu.LastName,
+		&stu.GPA,
+		&stu.Credits,
+		&stu.Sport,
+		&stu.GradYear,
+		&stu.GradeLevel,
+		&archived,
+		&stamp,
+		&updated)
+	if archived.Valid {
+		stu.ArchivedAt = &archived.Time
+	}
+	if stamp.Valid {
+		stu.CreatedAt = stamp.Time
+	}
+	if updated.Valid {
+		stu.UpdatedAt = updated.Time
+	}
+	return stu, err
+}
+
+// loadStudents returns the students matching the optional where clause.
+func loadStudents(db *sql.DB, where string, args ...interface{}) ([]*models.Student, error) {
+	query := "SELECT " + studentColumns + " FROM leaderboard.students"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stus := make([]*models.Student, 0)
+	for rows.Next() {
+		stu, err := scanStudent(rows)
+		if err != nil {
+			return nil, err
+		}
+		stus = append(stus, stu)
+	}
+	return stus, rows.Err()
+}
+
+// studentInSchool reports whether the student exists and belongs to school.
+func studentInSchool(db *sql.DB, school int, id int) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM leaderboard.students WHERE id = ? AND school_id = ?", id, school).Scan(&n)
+	return n > 0, err
+}
+
+// rankStudents orders students by GPA, highest first.
+func rankStudents(stus []*models.Student, chain []string) []*models.LeaderboardEntry {
+	entries := make([]*models.LeaderboardEntry, 0, len(stus))
+	for _, stu := range stus {
+		entries = append(entries, &models.LeaderboardEntry{Student: stu, Score: float64(stu.GPA)})
+	}
+	return rankEntries(entries, false, chain)
+}
+
+// rankEntries orders entries by score, highest first unless lowerIsBetter,
+// then by the tie-breaker chain. Entries that nothing separates share a rank
+// and the next rank is skipped (1, 2, 2, 4); they are listed by student ID
+// so that the order is total and pages stay stable.
+func rankEntries(entries []*models.LeaderboardEntry, lowerIsBetter bool, chain []string) []*models.LeaderboardEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		cmp, _ := compareEntries(entries[i], entries[j], lowerIsBetter, chain)
+		if cmp == 0 && entries[i].Student != nil && entries[j].Student != nil {
+			return entries[i].Student.ID < entries[j].Student.ID
+		}
+		return cmp < 0
+	})
+	for i, entry := range entries {
+		entry.Rank = i + 1
+		if i == 0 {
+			continue
+		}
+		_, entry.DecidedBy = compareEntries(entries[i-1], entry, lowerIsBetter, chain)
+		if entry.DecidedBy == models.DecidedByTie {
+			entry.Rank = entries[i-1].Rank
+		}
+	}
+	return entries
+}
+
+/******************************************************************************/
+
+// StudentLeaderboard ranks the students of the caller's school by GPA.
+func StudentLeaderboard(w http.ResponseWriter, r *http.Request) {
+	chain, err := tieBreakerChain(r, "gpa")
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	where := currentStudents
+	args := []interface{}{tenantOf(r).SchoolID}
+	if sport := r.URL.Query().Get("sport"); sport != "" {
+		where += " AND sport = ?"
+		args = append(args, sport)
+	}
+	stus, err := loadStudents(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	ev, err := loadEligibility(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	entries := rankStudents(stus, chain)
+	for _, entry := range entries {
+		entry.Eligibility = ev.evaluate(entry.Student, "")
+	}
+	writeEntryPage(w, r, entries, false, chain)
+}
+
+// DistrictLeaderboard ranks students across every school in the district.
+// It is only available to district accounts; `school` narrows it to a subset
+// of schools and may be repeated.
+func DistrictLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if !tenantOf(r).District {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	chain, err := tieBreakerChain(r, "district")
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	where := "archived_at IS NULL"
+	args := make([]interface{}, 0)
+	if schools := r.URL.Query()["school"]; len(schools) > 0 {
+		where += " AND school_id IN (?" + repeatPlaceholders(len(schools)-1) + ")"
+		for _, school := range schools {
+			id, err := strconv.Atoi(school)
+			if err != nil {
+				invalidField(w, "school", "must be numeric")
+				return
+			}
+			args = append(args, id)
+		}
+	}
+	if sport := r.URL.Query().Get("sport"); sport != "" {
+		where += " AND sport = ?"
+		args = append(args, sport)
+	}
+	stus, err := loadStudents(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	ev, err := loadEligibility(db, 0)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	entries := rankStudents(stus, chain)
+	for _, entry := range entries {
+		entry.Eligibility = ev.evaluate(entry.Student, "")
+	}
+	writeEntryPage(w, r, entries, false, chain)
+}
+
+// repeatPlaceholders returns n additional ", ?" placeholders.
+func repeatPlaceholders(n int) string {
+	s := ""
+	for i := 0; i < n; i++ {
+		s += ", ?"
+	}
+	return s
+}
@@ -0,0 +1,1039 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"leaderboard-bk/cmd/models"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Request bodies larger than this are refused before they are read.
+const maxBodyBytes = 1 << 20
+
+// An operation of the API, as listed in apiRoutes. The OpenAPI description
+// is built from these, and requests are validated against it, so adding a
+// route to main() means adding it here too.
+type endpoint struct {
+	method, path string
+	id, tag      string
+	summary      string
+	params       []*models.Parameter
+	// The JSON body the operation reads, nil when it reads none.
+	body         *models.Schema
+	optionalBody bool
+	status       int
+	// An example of the response body, nil when there is none.
+	returns interface{}
+	// The response content type, when it is not application/json.
+	contentType string
+	public      bool
+}
+
+func number() *models.Schema  { return &models.Schema{Type: "number"} }
+func integer() *models.Schema { return &models.Schema{Type: "integer"} }
+func boolean() *models.Schema { return &models.Schema{Type: "boolean"} }
+func str() *models.Schema     { return &models.Schema{Type: "string"} }
+
+// text is a string that must not be empty.
+func text() *models.Schema { return &models.Schema{Type: "string", MinLength: 1} }
+
+func date() *models.Schema     { return &models.Schema{Type: "string", Format: "date"} }
+func dateTime() *models.Schema { return &models.Schema{Type: "string", Format: "date-time"} }
+
+func oneOf(values ...string) *models.Schema { return &models.Schema{Type: "string", Enum: values} }
+
+func matching(pattern *regexp.Regexp) *models.Schema {
+	return &models.Schema{Type: "string", Pattern: pattern.String()}
+}
+
+func arrayOf(items *models.Schema) *models.Schema { return &models.Schema{Type: "array", Items: items} }
+
+func object(required []string, properties map[string]*models.Schema) *models.Schema {
+	return &models.Schema{Type: "object", Required: required, Properties: properties}
+}
+
+func limitOf(v float64) *float64 { return &v }
+
+func atLeast(s *models.Schema, min float64) *models.Schema {
+	s.Minimum = limitOf(min)
+	return s
+}
+
+func between(s *models.Schema, min, max float64) *models.Schema {
+	s.Minimum, s.Maximum = limitOf(min), limitOf(max)
+	return s
+}
+
+func positive(s *models.Schema) *models.Schema {
+	s.Minimum, s.ExclusiveMinimum = limitOf(0), true
+	return s
+}
+
+func documented(s *models.Schema, description string) *models.Schema {
+	s.Description = description
+	return s
+}
+
+// nonEmpty is an array with at least one item.
+func nonEmpty(s *models.Schema) *models.Schema {
+	s.MinItems = 1
+	return s
+}
+
+func nullable(s *models.Schema) *models.Schema {
+	s.Nullable = true
+	return s
+}
+
+func query(name string, s *models.Schema, description string) *models.Parameter {
+	return &models.Parameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+func requiredQuery(name string, s *models.Schema, description string) *models.Parameter {
+	p := query(name, s, description)
+	p.Required = true
+	return p
+}
+
+// paged adds the `limit` and `cursor` parameters of a list endpoint.
+func paged(params ...*models.Parameter) []*models.Parameter {
+	return append(params,
+		query("limit", atLeast(integer(), 1), "Page size; "+strconv.Itoa(defaultPageSize)+" by default and at most "+
+			strconv.Itoa(maxPageSize)+"."),
+		query("cursor", str(), "Where the page starts, from the next or prev link of the previous page."))
+}
+
+var (
+	sportParam    = query("sport", str(), "Only this sport.")
+	levelParam    = query("level", str(), "Only this team level: varsity, jv or freshman.")
+	tiebreakParam = query("tiebreak", str(), "Comma separated tie-breakers, overriding the board's own.")
+)
+
+func studentBody(creating bool) *models.Schema {
+	gpa := atLeast(number(), 0)
+	if creating {
+		gpa = documented(atLeast(integer(), 0), "Whole grade points only when creating a student.")
+	}
+	var required []string
+	if creating {
+		required = []string{"first_name", "last_name"}
+	}
+	return object(required, map[string]*models.Schema{
+		"first_name":  text(),
+		"last_name":   text(),
+		"gpa":         gpa,
+		"credits":     atLeast(number(), 0),
+		"sport":       str(),
+		"grad_year":   atLeast(integer(), 0),
+		"grade_level": between(integer(), 0, models.FinalGradeLevel),
+	})
+}
+
+func studentListParams() []*models.Parameter {
+	return paged(
+		query("filter", str(), "A filter such as gpa >= 3.5 and sport = \"soccer\"."),
+		query("sort", str(), "Comma separated fields, each optionally prefixed with - for descending."),
+		query("fields", str(), "Comma separated fields to return."),
+		query("archived", boolean(), "List graduates instead of current students."))
+}
+
+func boardBody(creating bool) *models.Schema {
+	var required []string
+	if creating {
+		required = []string{"slug", "name"}
+	}
+	return object(required, map[string]*models.Schema{
+		"slug": documented(matching(boardSlugPattern), "Taken from the path when updating."),
+		"name": text(),
+		"filter": object(nil, map[string]*models.Schema{
+			"sports":      arrayOf(str()),
+			"team_ids":    arrayOf(integer()),
+			"levels":      arrayOf(str()),
+			"min_gpa":     nullable(number()),
+			"max_gpa":     nullable(number()),
+			"min_credits": nullable(number()),
+			"grad_years":  arrayOf(integer()),
+			"query":       documented(str(), "A filter in the listing query language."),
+		}),
+		"score":        documented(str(), "gpa, credits or stat:<id>; gpa by default."),
+		"direction":    oneOf("asc", "desc"),
+		"tie_breakers": arrayOf(str()),
+		"tie_policy":   oneOf(models.TiePolicyShared, models.TiePolicyDense, models.TiePolicyUnique),
+		"visibility":   oneOf(models.VisibilityPublic, models.VisibilityStaff, models.VisibilityAdmin),
+	})
+}
+
+// apiRoutes lists every route served by main(), in the same order.
+var apiRoutes = []*endpoint{
+	{method: "post", path: "/api/signin", id: "signIn", tag: "session", public: true,
+		summary: "Signs in, setting the token cookie the other routes read.",
+		body: object([]string{"username", "password"}, map[string]*models.Schema{
+			"username": text(),
+			"password": text(),
+		}),
+		status: http.StatusOK},
+	{method: "get", path: "/api/welcome", id: "welcome", tag: "session",
+		summary: "Greets the signed-in user.", status: http.StatusOK, returns: "", contentType: "text/plain"},
+	{method: "post", path: "/api/refresh", id: "refreshToken", tag: "session",
+		summary: "Renews a token in the last 30 seconds before it expires.", status: http.StatusOK},
+	{method: "get", path: "/api/openapi.json", id: "openAPI", tag: "documentation", public: true,
+		summary: "This description of the API.", status: http.StatusOK, returns: map[string]interface{}{}},
+	{method: "get", path: "/api/docs", id: "docs", tag: "documentation", public: true,
+		summary: "A page documenting the API.", status: http.StatusOK, returns: "", contentType: "text/html"},
+
+	{method: "get", path: "/api/public/leaderboards/{slug}", id: "publicLeaderboard", tag: "public", public: true,
+		summary: "The published standings of a public board, following the school's privacy settings.",
+		params:  paged(requiredQuery("school", integer(), "The school whose board to read.")),
+		status:  http.StatusOK, returns: &models.PublicStandings{}},
+
+	{method: "get", path: "/api/all_students", id: "listStudents", tag: "students",
+		summary: "Lists the school's students; version 1 concatenates their JSON objects.",
+		params:  studentListParams(), status: http.StatusOK, returns: []*models.Student{}, contentType: "application/x-www-form-urlencoded"},
+	{method: "get", path: "/api/students", id: "listStudentsV2", tag: "students",
+		summary: "Lists the school's students. Version 2 only.",
+		params:  studentListParams(), status: http.StatusOK, returns: []*models.Student{}},
+	{method: "post", path: "/api/students", id: "createStudent", tag: "students",
+		summary: "Adds a student.", body: studentBody(true), status: http.StatusCreated, returns: &models.Student{}},
+	{method: "get", path: "/api/students/search", id: "searchStudents", tag: "students",
+		summary: "Finds students by name, tolerating typos and accents.",
+		params: paged(
+			requiredQuery("q", text(), "The name to look for."),
+			query("min_score", between(number(), 0, 1), "Drops matches scoring below this.")),
+		status: http.StatusOK, returns: []*models.SearchResult{}},
+	{method: "get", path: "/api/students/autocomplete", id: "autocompleteStudents", tag: "students",
+		summary: "Suggests students as a name is typed.",
+		params: []*models.Parameter{
+			query("q", str(), "The name typed so far."),
+			query("limit", atLeast(integer(), 1), "How many suggestions to make."),
+		},
+		status: http.StatusOK, returns: []*models.SearchResult{}},
+	{method: "get", path: "/api/students/{studentId}", id: "fetchStudent", tag: "students",
+		summary: "A student. Version 1 lists every student instead, as /api/all_students does.",
+		status:  http.StatusOK, returns: &models.Student{}},
+	{method: "put", path: "/api/students/{studentId}", id: "updateStudent", tag: "students",
+		summary: "Replaces a student's details.", body: studentBody(false), status: http.StatusNoContent},
+	{method: "delete", path: "/api/students/{studentId}", id: "deleteStudent", tag: "students",
+		summary: "Deletes a student.", status: http.StatusNoContent},
+	{method: "get", path: "/api/students/{studentId}/terms", id: "listStudentTerms", tag: "students",
+		summary: "Lists a student's terms, most recent first.",
+		params:  paged(), status: http.StatusOK, returns: []*models.TermRecord{}},
+	{method: "post", path: "/api/students/{studentId}/terms", id: "createStudentTerm", tag: "students",
+		summary: "Records a term's grades for a student.",
+		body: object([]string{"term", "ends_on"}, map[string]*models.Schema{
+			"term":    text(),
+			"gpa":     atLeast(number(), 0),
+			"credits": atLeast(number(), 0),
+			"ends_on": date(),
+		}),
+		status: http.StatusCreated, returns: &models.TermRecord{}},
+	{method: "get", path: "/api/students/{studentId}/badges", id: "listStudentBadges", tag: "badges",
+		summary: "Lists the badges a student has been awarded.",
+		params:  paged(), status: http.StatusOK, returns: []*models.BadgeAward{}},
+	{method: "get", path: "/api/students/{studentId}/rank_history", id: "studentRankHistory", tag: "leaderboards",
+		summary: "A student's rank over time on a board.",
+		params: []*models.Parameter{
+			query("board", str(), "The board; gpa by default."),
+			query("from", date(), "The first day, inclusive."),
+			query("to", date(), "The last day, inclusive."),
+			query("points", atLeast(integer(), 1), "At most this many points, merging neighbours over long ranges."),
+		},
+		status: http.StatusOK, returns: &models.RankHistory{}},
+	{method: "get", path: "/api/students/{studentId}/privacy", id: "fetchStudentPrivacy", tag: "privacy",
+		summary: "A student's choices for public leaderboards.",
+		status:  http.StatusOK, returns: &models.StudentPrivacy{}},
+	{method: "put", path: "/api/students/{studentId}/privacy", id: "updateStudentPrivacy", tag: "privacy",
+		summary: "Records whether a student opts out of public leaderboards and their alias. Administrators only.",
+		body: object(nil, map[string]*models.Schema{
+			"opt_out": boolean(),
+			"alias": documented(&models.Schema{Type: "string", MaxLength: 64},
+				"Letters, digits, spaces, dots, hyphens and apostrophes, without the student's name."),
+		}),
+		status: http.StatusOK, returns: &models.StudentPrivacy{}},
+	{method: "get", path: "/api/privacy", id: "fetchPrivacySettings", tag: "privacy",
+		summary: "The school's rules for public leaderboards.",
+		status:  http.StatusOK, returns: &models.PrivacySettings{}},
+	{method: "put", path: "/api/privacy", id: "updatePrivacySettings", tag: "privacy",
+		summary: "Replaces the school's rules for public leaderboards. Administrators only.",
+		body: object([]string{"name_style", "band_width", "min_group_size"}, map[string]*models.Schema{
+			"name_style":     oneOf(models.NameStyleInitials, models.NameStyleFirstName),
+			"band_width":     positive(number()),
+			"min_group_size": atLeast(integer(), 1),
+		}),
+		status: http.StatusOK, returns: &models.PrivacySettings{}},
+	{method: "get", path: "/api/badges", id: "listBadges", tag: "badges",
+		summary: "Lists the school's badges.", params: paged(), status: http.StatusOK, returns: []*models.Badge{}},
+	{method: "post", path: "/api/badges", id: "createBadge", tag: "badges",
+		summary: "Adds a badge, or replaces the one with the same key.",
+		body: object([]string{"key", "name", "rule"}, map[string]*models.Schema{
+			"key":         matching(badgeKeyPattern),
+			"name":        text(),
+			"description": str(),
+			"rule": object([]string{"kind"}, map[string]*models.Schema{
+				"kind":            oneOf(models.BadgeTermGPA, models.BadgeStreak, models.BadgeImprovement),
+				"min_gpa":         number(),
+				"min_credits":     number(),
+				"terms":           atLeast(integer(), 0),
+				"min_improvement": number(),
+			}),
+		}),
+		status: http.StatusCreated, returns: &models.Badge{}},
+	{method: "get", path: "/api/badges/{badgeKey}/holders", id: "listBadgeHolders", tag: "badges",
+		summary: "Lists the students holding a badge.",
+		params:  paged(), status: http.StatusOK, returns: []*models.BadgeHolder{}},
+	{method: "get", path: "/api/teams", id: "listTeams", tag: "teams",
+		summary: "Lists the school's teams.",
+		params:  paged(sportParam, levelParam), status: http.StatusOK, returns: []*models.Team{}},
+	{method: "post", path: "/api/teams", id: "createTeam", tag: "teams",
+		summary: "Adds a team, with any members given.",
+		body: object([]string{"name", "sport", "level"}, map[string]*models.Schema{
+			"name":    text(),
+			"sport":   text(),
+			"level":   documented(str(), "varsity, jv or freshman."),
+			"members": arrayOf(integer()),
+		}),
+		status: http.StatusCreated, returns: &models.Team{}},
+	{method: "get", path: "/api/teams/{teamId}", id: "fetchTeam", tag: "teams",
+		summary: "A team and its members' IDs.", status: http.StatusOK, returns: &models.Team{}},
+	{method: "delete", path: "/api/teams/{teamId}", id: "deleteTeam", tag: "teams",
+		summary: "Deletes a team.", status: http.StatusNoContent},
+	{method: "get", path: "/api/teams/{teamId}/members", id: "listTeamMembers", tag: "teams",
+		summary: "A team's roster with each member's eligibility.",
+		params:  paged(), status: http.StatusOK, returns: []*models.RosterEntry{}},
+	{method: "post", path: "/api/teams/{teamId}/members", id: "addTeamMember", tag: "teams",
+		summary: "Adds a student to a team.",
+		body: object([]string{"student_id"}, map[string]*models.Schema{
+			"student_id": atLeast(integer(), 1),
+		}),
+		status: http.StatusNoContent},
+	{method: "delete", path: "/api/teams/{teamId}/members/{studentId}", id: "removeTeamMember", tag: "teams",
+		summary: "Removes a student from a team.", status: http.StatusNoContent},
+	{method: "get", path: "/api/leaderboards/teams", id: "teamLeaderboard", tag: "leaderboards",
+		summary: "Ranks the school's teams by their members' GPAs.",
+		params: paged(sportParam, levelParam,
+			query("aggregate", oneOf(models.AggregateMean, models.AggregateMedian, models.AggregateMinimum),
+				"How members' GPAs make a team score; mean by default. The minimum counts only members eligible to play for the team.")),
+		status: http.StatusOK, returns: []*models.TeamStanding{}},
+	{method: "get", path: "/api/leaderboard", id: "studentLeaderboard", tag: "leaderboards",
+		summary: "Ranks the school's current students by GPA.",
+		params:  paged(sportParam, tiebreakParam), status: http.StatusOK, returns: []*models.LeaderboardEntry{}},
+	{method: "get", path: "/api/district/leaderboard", id: "districtLeaderboard", tag: "leaderboards",
+		summary: "Ranks students across the district's schools. District accounts only.",
+		params: paged(
+			query("school", arrayOf(integer()), "Only these schools; repeat the parameter for each."),
+			sportParam, tiebreakParam),
+		status: http.StatusOK, returns: []*models.LeaderboardEntry{}},
+	{method: "get", path: "/api/leaderboards/improvement", id: "improvementLeaderboard", tag: "leaderboards",
+		summary: "Ranks students by GPA improvement between two terms or two snapshots.",
+		params: paged(
+			query("from", str(), "The earlier term."),
+			query("to", str(), "The later term."),
+			query("from_snapshot", str(), "The earlier snapshot, instead of a term."),
+			query("to_snapshot", str(), "The later snapshot, instead of a term."),
+			query("mode", oneOf("absolute", "relative"), "absolute by default."),
+			query("min_credits", atLeast(number(), 0), "Leaves out terms with fewer credits."),
+			tiebreakParam),
+		status: http.StatusOK, returns: []*models.LeaderboardEntry{}},
+	{method: "get", path: "/api/snapshots", id: "listSnapshots", tag: "leaderboards",
+		summary: "Lists the school's snapshots.", params: paged(), status: http.StatusOK, returns: []*models.Snapshot{}},
+	{method: "get", path: "/api/distribution", id: "distribution", tag: "leaderboards",
+		summary: "Summarises a score across the school, optionally grouped.",
+		params: []*models.Parameter{
+			query("score", str(), "gpa, credits or stat:<id>; gpa by default."),
+			query("group", str(), "sport, team or term."),
+			query("board", str(), "Summarise the students on this board instead."),
+			query("edges", str(), "Comma separated ascending bucket boundaries."),
+			query("width", positive(number()), "Bucket width; 0.5 by default."),
+			query("percentiles", str(), "Comma separated percentiles between 0 and 100."),
+		},
+		status: http.StatusOK, returns: &models.DistributionReport{}},
+	{method: "get", path: "/api/compare", id: "compare", tag: "leaderboards",
+		summary: "Puts students or teams side by side; the first one listed is the baseline.",
+		params: []*models.Parameter{
+			query("students", str(), "Comma separated student IDs."),
+			query("teams", str(), "Comma separated team IDs."),
+		},
+		status: http.StatusOK, returns: &models.Comparison{}},
+	{method: "get", path: "/api/boards/{slug}/publications", id: "listPublications", tag: "publications",
+		summary: "Lists a board's publications, newest first.",
+		params: paged(query("status", oneOf(models.PublicationDraft, models.PublicationPublished, models.PublicationRetired,
+			models.PublicationDiscarded), "Only publications with this status.")),
+		status: http.StatusOK, returns: []*models.Publication{}},
+	{method: "post", path: "/api/boards/{slug}/publications", id: "createPublication", tag: "publications",
+		summary: "Computes a board into a new draft for review.",
+		body: object(nil, map[string]*models.Schema{
+			"note": documented(str(), "For the reviewer."),
+		}),
+		optionalBody: true, status: http.StatusCreated, returns: &models.Publication{}},
+	{method: "get", path: "/api/boards/{slug}/publications/{publicationId}", id: "fetchPublication", tag: "publications",
+		summary: "A publication with a page of its frozen standings.",
+		params:  paged(), status: http.StatusOK, returns: &models.Publication{}},
+	{method: "post", path: "/api/boards/{slug}/publications/{publicationId}/approve", id: "approvePublication",
+		tag: "publications", summary: "Publishes a draft. Publishers and administrators only.",
+		status: http.StatusOK, returns: &models.Publication{}},
+	{method: "post", path: "/api/boards/{slug}/publications/{publicationId}/rollback", id: "rollbackPublication",
+		tag: "publications", summary: "Publishes a retired publication again. Publishers and administrators only.",
+		status: http.StatusOK, returns: &models.Publication{}},
+	{method: "post", path: "/api/boards/{slug}/publications/{publicationId}/discard", id: "discardPublication",
+		tag: "publications", summary: "Drops a draft.", status: http.StatusOK, returns: &models.Publication{}},
+	{method: "get", path: "/api/boards", id: "listBoards", tag: "boards",
+		summary: "Lists the built-in, class and saved boards the caller can view.",
+		params:  paged(), status: http.StatusOK, returns: []*models.Leaderboard{}},
+	{method: "post", path: "/api/boards", id: "createBoard", tag: "boards",
+		summary: "Saves a new board.", body: boardBody(true), status: http.StatusCreated, returns: &models.Leaderboard{}},
+	{method: "get", path: "/api/boards/{slug}/events", id: "boardEvents", tag: "boards",
+		summary: "Streams a board's changes as server-sent events: a snapshot, then entry, rank and removal " +
+			"events. Send Last-Event-ID to resume.",
+		status: http.StatusOK, returns: "", contentType: "text/event-stream"},
+	{method: "get", path: "/api/boards/{slug}", id: "fetchBoard", tag: "boards",
+		summary: "A board with a page of its current standings.",
+		params:  paged(), status: http.StatusOK, returns: &models.BoardStandings{}},
+	{method: "put", path: "/api/boards/{slug}", id: "updateBoard", tag: "boards",
+		summary: "Replaces a saved board.", body: boardBody(false), status: http.StatusOK, returns: &models.Leaderboard{}},
+	{method: "delete", path: "/api/boards/{slug}", id: "deleteBoard", tag: "boards",
+		summary: "Deletes a saved board.", status: http.StatusNoContent},
+	{method: "get", path: "/api/sport_stats", id: "listStats", tag: "stats",
+		summary: "Lists the school's sport stats.",
+		params:  paged(sportParam), status: http.StatusOK, returns: []*models.StatDefinition{}},
+	{method: "post", path: "/api/sport_stats", id: "createStat", tag: "stats",
+		summary: "Defines a sport stat.",
+		body: object([]string{"sport", "name"}, map[string]*models.Schema{
+			"sport":           text(),
+			"name":            text(),
+			"unit":            str(),
+			"precision":       documented(between(integer(), 0, 6), "Decimal places values are rounded to; 2 when left out."),
+			"lower_is_better": boolean(),
+		}),
+		status: http.StatusCreated, returns: &models.StatDefinition{}},
+	{method: "delete", path: "/api/sport_stats/{statId}", id: "deleteStat", tag: "stats",
+		summary: "Deletes a sport stat and its entries.", status: http.StatusNoContent},
+	{method: "get", path: "/api/sport_stats/{statId}/entries", id: "listStatEntries", tag: "stats",
+		summary: "Lists a stat's entries.",
+		params:  paged(query("student", integer(), "Only this student's entries.")),
+		status:  http.StatusOK, returns: []*models.StatEntry{}},
+	{method: "post", path: "/api/sport_stats/{statId}/entries", id: "createStatEntry", tag: "stats",
+		summary: "Records a student's result for a stat.",
+		body: object([]string{"student_id", "value"}, map[string]*models.Schema{
+			"student_id":  atLeast(integer(), 1),
+			"value":       number(),
+			"recorded_at": documented(dateTime(), "Now by default."),
+		}),
+		status: http.StatusCreated, returns: &models.StatEntry{}},
+	{method: "get", path: "/api/leaderboards/sport_stats/{statId}", id: "statLeaderboard", tag: "leaderboards",
+		summary: "Ranks students by their best entry for a stat.",
+		params:  paged(tiebreakParam), status: http.StatusOK, returns: []*models.LeaderboardEntry{}},
+	{method: "post", path: "/api/snapshots", id: "createSnapshot", tag: "leaderboards",
+		summary: "Copies every student's GPA and credits into a snapshot.",
+		body: object([]string{"label"}, map[string]*models.Schema{
+			"label": text(),
+		}),
+		status: http.StatusCreated, returns: &models.Snapshot{}},
+	{method: "get", path: "/api/eligibility/rules", id: "listEligibilityRules", tag: "eligibility",
+		summary: "Lists the school's eligibility rules.",
+		params:  paged(), status: http.StatusOK, returns: []*models.EligibilityRule{}},
+	{method: "post", path: "/api/eligibility/rules", id: "createEligibilityRule", tag: "eligibility",
+		summary: "Adds an eligibility rule, or replaces the one for the same sport and level.",
+		body: object([]string{"sport"}, map[string]*models.Schema{
+			"sport":       text(),
+			"level":       documented(str(), "varsity, jv or freshman; every level by default."),
+			"min_gpa":     atLeast(number(), 0),
+			"min_credits": atLeast(number(), 0),
+			"grace_terms": atLeast(integer(), 0),
+		}),
+		status: http.StatusCreated, returns: &models.EligibilityRule{}},
+	{method: "delete", path: "/api/eligibility/rules/{ruleId}", id: "deleteEligibilityRule", tag: "eligibility",
+		summary: "Deletes an eligibility rule.", status: http.StatusNoContent},
+	{method: "get", path: "/api/eligibility/report", id: "eligibilityReport", tag: "eligibility",
+		summary: "Every student's eligibility.",
+		params: paged(sportParam, levelParam,
+			query("status", oneOf(models.EligibilityEligible, models.EligibilityProbation, models.EligibilityIneligible),
+				"Only students with this status.")),
+		status: http.StatusOK, returns: []*models.EligibilityReportEntry{}},
+	{method: "get", path: "/api/hall_of_fame/records", id: "listRecords", tag: "hall of fame",
+		summary: "The school's current all-time records.", params: paged(), status: http.StatusOK, returns: []*models.Record{}},
+	{method: "get", path: "/api/hall_of_fame/records/{recordKey}", id: "recordHistory", tag: "hall of fame",
+		summary: "Every holder of a record, latest first.", params: paged(), status: http.StatusOK, returns: []*models.Record{}},
+	{method: "get", path: "/api/hall_of_fame/seasons", id: "listSeasonWinners", tag: "hall of fame",
+		summary: "The leaders of archived seasons.",
+		params:  paged(query("season", str(), "Only this season."), query("board", str(), "Only this board.")),
+		status:  http.StatusOK, returns: []*models.SeasonWinner{}},
+	{method: "post", path: "/api/hall_of_fame/seasons", id: "archiveSeason", tag: "hall of fame",
+		summary: "Copies the current leaders of the school's boards into the hall of fame. Administrators only.",
+		body: object([]string{"season"}, map[string]*models.Schema{
+			"season": &models.Schema{Type: "string", MinLength: 1, MaxLength: maxSeasonLength},
+			"boards": documented(arrayOf(str()), "Every board by default."),
+			"top":    documented(atLeast(integer(), 0), "How many leaders of each board; "+strconv.Itoa(defaultSeasonTop)+" by default."),
+		}),
+		status: http.StatusCreated, returns: []*models.SeasonWinner{}},
+	{method: "get", path: "/api/cohorts", id: "listCohorts", tag: "cohorts",
+		summary: "The school's graduating classes, latest first.", params: paged(), status: http.StatusOK, returns: []*models.Cohort{}},
+	{method: "get", path: "/api/cohorts/promotions", id: "listPromotions", tag: "cohorts",
+		summary: "The school's promotion runs, latest first.", params: paged(), status: http.StatusOK, returns: []*models.Promotion{}},
+	{method: "post", path: "/api/cohorts/promotions", id: "promoteSchool", tag: "cohorts",
+		summary: "Runs the annual promotion now. Administrators only.", status: http.StatusCreated, returns: &models.Promotion{}},
+	{method: "get", path: "/api/webhooks", id: "listWebhooks", tag: "webhooks",
+		summary: "The school's webhooks, without their secrets. Administrators only.", params: paged(),
+		status: http.StatusOK, returns: []*models.Webhook{}},
+	{method: "post", path: "/api/webhooks", id: "createWebhook", tag: "webhooks",
+		summary: "Subscribes a URL to changes on some of the school's boards. Administrators only.",
+		body: object([]string{"url", "events", "boards"}, map[string]*models.Schema{
+			"url":    documented(text(), "An absolute http or https URL, POSTed each event. It must not resolve to a private, loopback or link-local address."),
+			"events": nonEmpty(arrayOf(oneOf(models.WebhookBoardEntry, models.WebhookBoardRank, models.WebhookBoardRemoval))),
+			"boards": nonEmpty(arrayOf(matching(boardSlugPattern))),
+			"secret": documented(&models.Schema{Type: "string", MinLength: minSecretLength, MaxLength: maxSecretLength},
+				"Signs deliveries in the "+signatureHeader+" header; generated when left out, and only shown in this response."),
+		}),
+		status: http.StatusCreated, returns: &models.Webhook{}},
+	{method: "get", path: "/api/webhooks/{webhookId}", id: "fetchWebhook", tag: "webhooks",
+		summary: "A webhook, without its secret. Administrators only.", status: http.StatusOK, returns: &models.Webhook{}},
+	{method: "delete", path: "/api/webhooks/{webhookId}", id: "deleteWebhook", tag: "webhooks",
+		summary: "Unsubscribes a webhook, dropping its delivery log. Administrators only.", status: http.StatusNoContent},
+	{method: "get", path: "/api/webhooks/{webhookId}/deliveries", id: "listWebhookDeliveries", tag: "webhooks",
+		summary: "A webhook's delivery log, newest first. Administrators only.",
+		params: paged(query("status", oneOf(models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead),
+			"Only deliveries with this status.")),
+		status: http.StatusOK, returns: []*models.WebhookDelivery{}},
+	{method: "post", path: "/api/webhooks/{webhookId}/deliveries/{deliveryId}/retry", id: "retryWebhookDelivery",
+		tag: "webhooks", summary: "Gives a dead delivery a fresh set of attempts. Administrators only.",
+		status: http.StatusOK, returns: &models.WebhookDelivery{}},
+	{method: "get", path: "/api/metrics", id: "metrics", tag: "documentation",
+		summary: "Request counts by API version and route, with the rest of the server's expvar variables. Administrators only.",
+		status:  http.StatusOK, returns: map[string]interface{}{}},
+}
+
+// pathParams are the parameters named in a path template. IDs are
+// integers; everything else is a string.
+var pathParamPattern = regexp.MustCompile(`{([^}]+)}`)
+
+func pathParams(path string) []*models.Parameter {
+	params := make([]*models.Parameter, 0)
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		s := str()
+		if strings.HasSuffix(match[1], "Id") {
+			s = atLeast(integer(), 1)
+		}
+		params = append(params, &models.Parameter{Name: match[1], In: "path", Required: true, Schema: s})
+	}
+	return params
+}
+
+// schemaOf describes the JSON encoding of a Go type. Structs become
+// component schemas, named after the type; fields without omitempty are
+// always present and so are listed as required.
+func schemaOf(t reflect.Type, schemas map[string]*models.Schema) *models.Schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return dateTime()
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Bool:
+		return boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return integer()
+	case reflect.Float32, reflect.Float64:
+		return number()
+	case reflect.String:
+		return str()
+	case reflect.Slice, reflect.Array:
+		return arrayOf(schemaOf(t.Elem(), schemas))
+	case reflect.Map:
+		return &models.Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			s := object(nil, make(map[string]*models.Schema))
+			schemas[t.Name()] = s
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				tag := field.Tag.Get("json")
+				name := strings.Split(tag, ",")[0]
+				if field.PkgPath != "" || name == "-" {
+					continue
+				}
+				if name == "" {
+					name = field.Name
+				}
+				s.Properties[name] = schemaOf(field.Type, schemas)
+				if !strings.Contains(tag, ",omitempty") {
+					s.Required = append(s.Required, name)
+				}
+			}
+		}
+		return &models.Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &models.Schema{}
+}
+
+func jsonContent(contentType string, s *models.Schema) map[string]*models.MediaType {
+	return map[string]*models.MediaType{contentType: {Schema: s}}
+}
+
+// buildSpec describes apiRoutes.
+func buildSpec() *models.OpenAPI {
+	spec := &models.OpenAPI{
+		OpenAPI: "3.0.3",
+		Info: models.APIInfo{
+			Title:   "Leaderboard API",
+			Version: strconv.Itoa(latestAPI),
+			Description: "Student and team leaderboards for schools. Errors are RFC 7807 problem details; " +
+				"list endpoints page with limit and cursor and link neighbouring pages in a Link header. " +
+				"Every path is served in version 1, the legacy default, and in version 2, chosen with the " +
+				"/api/v2 prefix (/api/v2/students for /api/students) or an Accept header of " +
+				"application/vnd.leaderboard.v2+json. Version 1 responses carry a Deprecation header. " +
+				"Leaderboard and student responses carry an ETag and Last-Modified; send them back in If-None-Match " +
+				"or If-Modified-Since to be answered 304 while the school's data is unchanged. " +
+				"Requests are rate limited by address, user or X-API-Key depending on the route; responses carry " +
+				"RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset, and refused requests are answered 429 " +
+				"with Retry-After.",
+		},
+		Paths: make(map[string]map[string]*models.Operation),
+		Components: models.Components{
+			Schemas: make(map[string]*models.Schema),
+			SecuritySchemes: map[string]*models.SecurityScheme{
+				"token": {Type: "apiKey", In: "cookie", Name: "token", Description: "Set by /api/signin."},
+			},
+		},
+	}
+	problemSchema := schemaOf(reflect.TypeOf(models.Problem{}), spec.Components.Schemas)
+	for _, e := range apiRoutes {
+		op := &models.Operation{
+			OperationID: e.id,
+			Summary:     e.summary,
+			Tags:        []string{e.tag},
+			Parameters:  append(pathParams(e.path), e.params...),
+			Responses: map[string]*models.Response{
+				"default": {Description: "A problem", Content: jsonContent("application/problem+json", problemSchema)},
+			},
+		}
+		if !e.public {
+			op.Security = []map[string][]string{{"token": {}}}
+		}
+		if e.body != nil {
+			op.RequestBody = &models.RequestBody{Required: !e.optionalBody, Content: jsonContent("application/json", e.body)}
+		}
+		response := &models.Response{Description: http.StatusText(e.status)}
+		if e.returns != nil {
+			contentType := e.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			response.Content = jsonContent(contentType, schemaOf(reflect.TypeOf(e.returns), spec.Components.Schemas))
+		}
+		op.Responses[strconv.Itoa(e.status)] = response
+		if spec.Paths[e.path] == nil {
+			spec.Paths[e.path] = make(map[string]*models.Operation)
+		}
+		spec.Paths[e.path][e.method] = op
+	}
+	return spec
+}
+
+var apiSpec = buildSpec()
+
+// UndocumentedRoutes lists the routes of router missing from the OpenAPI
+// description, as "METHOD /path".
+func UndocumentedRoutes(router *mux.Router) []string {
+	missing := make([]string, 0)
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			if len(apiSpec.Paths[path]) == 0 {
+				missing = append(missing, "* "+path)
+			}
+			return nil
+		}
+		for _, method := range methods {
+			if apiSpec.Paths[path][strings.ToLower(method)] == nil {
+				missing = append(missing, method+" "+path)
+			}
+		}
+		return nil
+	})
+	return missing
+}
+
+/******************************************************************************/
+
+// schemaValidator checks values against schemas, collecting every fault.
+type schemaValidator struct {
+	faults []*models.FieldError
+}
+
+func (v *schemaValidator) fault(field, detail string) {
+	v.faults = append(v.faults, &models.FieldError{Field: field, Detail: detail})
+}
+
+// choices lists values as "a, b or c".
+func choices(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
+func formatLimit(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// check validates a decoded JSON value, reporting faults against field.
+func (v *schemaValidator) check(field string, value interface{}, s *models.Schema) {
+	if s.Ref != "" {
+		s = apiSpec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if value == nil {
+		if !s.Nullable && s.Type != "" {
+			v.fault(field, "must not be null")
+		}
+		return
+	}
+	switch s.Type {
+	case "integer", "number":
+		var f float64
+		switch n := value.(type) {
+		case json.Number:
+			var err error
+			if f, err = n.Float64(); err != nil {
+				v.fault(field, "must be a number")
+				return
+			}
+		case float64:
+			f = n
+		default:
+			v.fault(field, "must be a number")
+			return
+		}
+		if s.Type == "integer" && f != math.Trunc(f) {
+			v.fault(field, "must be an integer")
+			return
+		}
+		switch {
+		case s.Minimum != nil && s.ExclusiveMinimum && f <= *s.Minimum:
+			v.fault(field, "must be greater than "+formatLimit(*s.Minimum))
+		case s.Minimum != nil && f < *s.Minimum:
+			v.fault(field, "must be at least "+formatLimit(*s.Minimum))
+		case s.Maximum != nil && f > *s.Maximum:
+			v.fault(field, "must be at most "+formatLimit(*s.Maximum))
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			v.fault(field, "must be a string")
+			return
+		}
+		v.checkString(field, text, s)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fault(field, "must be a boolean")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fault(field, "must be an array")
+			return
+		}
+		if s.MinItems > 0 && len(items) < s.MinItems {
+			v.fault(field, "must have at least "+strconv.Itoa(s.MinItems)+" items")
+		}
+		if s.MaxItems > 0 && len(items) > s.MaxItems {
+			v.fault(field, "must have at most "+strconv.Itoa(s.MaxItems)+" items")
+		}
+		for i, item := range items {
+			v.check(field+"["+strconv.Itoa(i)+"]", item, s.Items)
+		}
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			v.fault(field, "must be an object")
+			return
+		}
+		member := func(name string) string {
+			if field == "" {
+				return name
+			}
+			return field + "." + name
+		}
+		for _, name := range s.Required {
+			if _, ok := fields[name]; !ok {
+				v.fault(member(name), "is required")
+			}
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok {
+				v.check(member(name), fields[name], property)
+			} else if s.AdditionalProperties != nil {
+				v.check(member(name), fields[name], s.AdditionalProperties)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) checkString(field, text string, s *models.Schema) {
+	length := utf8.RuneCountInString(text)
+	switch {
+	case s.MinLength == 1 && length == 0:
+		v.fault(field, "is required")
+	case length < s.MinLength:
+		v.fault(field, "must be at least "+strconv.Itoa(s.MinLength)+" characters")
+	case s.MaxLength > 0 && length > s.MaxLength:
+		v.fault(field, "must be at most "+strconv.Itoa(s.MaxLength)+" characters")
+	case len(s.Enum) > 0:
+		for _, allowed := range s.Enum {
+			if text == allowed {
+				return
+			}
+		}
+		v.fault(field, "must be one of "+choices(s.Enum))
+	case s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(text):
+		v.fault(field, "must match "+s.Pattern)
+	case s.Format == "date":
+		if _, err := time.Parse("2006-01-02", text); err != nil {
+			v.fault(field, "must be a date such as 2020-06-15")
+		}
+	case s.Format == "date-time":
+		if _, err := time.Parse(time.RFC3339, text); err != nil {
+			v.fault(field, "must be a time such as 2020-06-15T15:04:05Z")
+		}
+	}
+}
+
+// checkParam validates the values given for a path or query parameter.
+func (v *schemaValidator) checkParam(p *models.Parameter, values []string) {
+	if len(values) == 0 || values[0] == "" {
+		if p.Required {
+			v.fault(p.Name, "is required")
+		}
+		return
+	}
+	s := p.Schema
+	if s.Type != "array" {
+		values = values[:1]
+	} else {
+		s = s.Items
+	}
+	for _, raw := range values {
+		switch s.Type {
+		case "integer", "number":
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil && s.Type == "integer" {
+				v.fault(p.Name, "must be an integer")
+				continue
+			}
+			if err != nil {
+				v.fault(p.Name, "must be a number")
+				continue
+			}
+			v.check(p.Name, f, s)
+		case "boolean":
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				v.fault(p.Name, "must be true or false")
+				continue
+			}
+			v.check(p.Name, b, s)
+		default:
+			v.check(p.Name, raw, s)
+		}
+	}
+}
+
+// operationOf finds the operation described for a request's route.
+func operationOf(r *http.Request) *models.Operation {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	return apiSpec.Paths[path][strings.ToLower(r.Method)]
+}
+
+// ValidateRequest checks a request's parameters and JSON body against the
+// OpenAPI description of its route before handing it on, answering 400
+// with every fault found. Routes without a description are passed through.
+func ValidateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := operationOf(r)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		v := new(schemaValidator)
+		vars, values := mux.Vars(r), r.URL.Query()
+		for _, p := range op.Parameters {
+			if p.In == "path" {
+				v.checkParam(p, []string{vars[p.Name]})
+			} else {
+				v.checkParam(p, values[p.Name])
+			}
+		}
+		if op.RequestBody != nil && r.Body != nil {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				invalid(w, err)
+				return
+			}
+			if len(body) > maxBodyBytes {
+				problem(w, http.StatusRequestEntityTooLarge, "bodies are limited to "+strconv.Itoa(maxBodyBytes)+" bytes")
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if len(bytes.TrimSpace(body)) == 0 {
+				if op.RequestBody.Required {
+					invalid(w, errors.New("a JSON body is required"))
+					return
+				}
+			} else {
+				decoder := json.NewDecoder(bytes.NewReader(body))
+				decoder.UseNumber()
+				var doc interface{}
+				if err := decoder.Decode(&doc); err != nil {
+					invalid(w, err)
+					return
+				}
+				v.check("", doc, op.RequestBody.Content["application/json"].Schema)
+			}
+		}
+		if len(v.faults) > 0 {
+			for _, fault := range v.faults {
+				if fault.Field == "" {
+					fault.Field = "body"
+				}
+			}
+			invalidFields(w, v.faults...)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/******************************************************************************/
+
+// ServeOpenAPI serves the OpenAPI description of the API.
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiSpec)
+}
+
+// ServeDocs serves a page rendering the OpenAPI description.
+func ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = io.WriteString(w, docsPage)
+}
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Leaderboard API</title>
+<style>
+body { font: 15px/1.5 sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; text-transform: capitalize; }
+details { margin: .5em 0; border: 1px solid #ddd; border-radius: 4px; padding: .3em .6em; }
+summary { cursor: pointer; }
+.method { display: inline-block; width: 4.5em; font-weight: bold; text-transform: uppercase; }
+code, pre { font-size: 13px; background: #f6f6f6; }
+pre { padding: .5em; overflow-x: auto; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: .2em .8em .2em 0; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>Leaderboard API</h1>
+<p id="description"></p>
+<p>The machine readable description is at <a href="/api/openapi.json">/api/openapi.json</a>.</p>
+<div id="operations"></div>
+<script>
+function el(tag, text) {
+  var e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+function documented(schema) {
+  return JSON.stringify(schema, null, 2);
+}
+fetch("/api/openapi.json").then(function (res) { return res.json(); }).then(function (spec) {
+  document.getElementById("description").textContent = spec.info.description;
+  var byTag = {};
+  Object.keys(spec.paths).sort().forEach(function (path) {
+    Object.keys(spec.paths[path]).forEach(function (method) {
+      var op = spec.paths[path][method];
+      (byTag[op.tags[0]] = byTag[op.tags[0]] || []).push({ path: path, method: method, op: op });
+    });
+  });
+  var root = document.getElementById("operations");
+  Object.keys(byTag).sort().forEach(function (tag) {
+    root.appendChild(el("h2", tag));
+    byTag[tag].forEach(function (entry) {
+      var op = entry.op, details = el("details"), summary = el("summary");
+      summary.appendChild(el("span", entry.method)).className = "method";
+      summary.appendChild(el("code", entry.path));
+      summary.appendChild(document.createTextNode(" " + op.summary));
+      details.appendChild(summary);
+      if (!op.security) details.appendChild(el("p", "No sign-in needed."));
+      if (op.parameters && op.parameters.length) {
+        var table = el("table"), head = el("tr");
+        ["Parameter", "In", "Schema", ""].forEach(function (h) { head.appendChild(el("th", h)); });
+        table.appendChild(head);
+        op.parameters.forEach(function (p) {
+          var row = el("tr");
+          row.appendChild(el("td")).appendChild(el("code", p.name + (p.required ? " *" : "")));
+          row.appendChild(el("td", p.in));
+          row.appendChild(el("td")).appendChild(el("code", JSON.stringify(p.schema)));
+          row.appendChild(el("td", p.description || ""));
+          table.appendChild(row);
+        });
+        details.appendChild(table);
+      }
+      if (op.requestBody) {
+        details.appendChild(el("h4", "Body" + (op.requestBody.required ? "" : " (optional)")));
+        details.appendChild(el("pre", documented(op.requestBody.content["application/json"].schema)));
+      }
+      Object.keys(op.responses).sort().forEach(function (status) {
+        var response = op.responses[status];
+        details.appendChild(el("h4", status + " " + response.description));
+        if (response.content) {
+          var type = Object.keys(response.content)[0];
+          details.appendChild(el("pre", type + "\n" + documented(response.content[type].schema)));
+        }
+      });
+      root.appendChild(details);
+    });
+  });
+  var schemas = spec.components.schemas;
+  root.appendChild(el("h2", "Schemas"));
+  Object.keys(schemas).sort().forEach(function (name) {
+    var details = el("details");
+    details.id = name;
+    details.appendChild(el("summary", name));
+    details.appendChild(el("pre", documented(schemas[name])));
+    root.appendChild(details);
+  });
+});
+</script>
+</body>
+</html>
+`
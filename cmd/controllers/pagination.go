@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for the `limit` parameter of every list endpoint.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// cursor is the opaque position handed out in next and prev links. It holds
+// the sort key of the item the page starts after (or ends before) rather
+// than an offset, so pages stay correct while items move around.
+type cursor struct {
+	Keys []interface{} `json:"k"`
+	// "next" pages start after Keys, "prev" pages end before them.
+	Dir string `json:"d"`
+	// The sort the keys belong to, where the caller chooses it.
+	Sort string `json:"s,omitempty"`
+}
+
+func encodeCursor(c *cursor) string {
+	bytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, &QueryError{"cursor", -1, "malformed cursor"}
+	}
+	c := new(cursor)
+	if err := json.Unmarshal(bytes, c); err != nil || (c.Dir != "next" && c.Dir != "prev") || len(c.Keys) == 0 {
+		return nil, &QueryError{"cursor", -1, "malformed cursor"}
+	}
+	for _, key := range c.Keys {
+		switch key.(type) {
+		case string, float64:
+		default:
+			return nil, &QueryError{"cursor", -1, "malformed cursor"}
+		}
+	}
+	return c, nil
+}
+
+// pageParams reads `limit` and `cursor`. A missing cursor is the first page.
+func pageParams(r *http.Request) (int, *cursor, error) {
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, nil, &QueryError{"limit", -1, "must be a positive integer"}
+		}
+		limit = n
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		return limit, c, err
+	}
+	return limit, nil, nil
+}
+
+// setPageLinks advertises the neighbouring pages in a Link header.
+func setPageLinks(w http.ResponseWriter, r *http.Request, next, prev *cursor) {
+	links := make([]string, 0, 2)
+	for _, link := range []struct {
+		rel string
+		c   *cursor
+	}{{"next", next}, {"prev", prev}} {
+		if link.c == nil {
+			continue
+		}
+		u := clientURL(r)
+		q := u.Query()
+		q.Set("cursor", encodeCursor(link.c))
+		u.RawQuery = q.Encode()
+		links = append(links, "<"+u.RequestURI()+">; rel=\""+link.rel+"\"")
+	}
+	if len(links) > 0 {
+		w.Header().Add("Link", strings.Join(links, ", "))
+	}
+}
+
+// compareKeys compares two sort keys position by position. Numbers arrive as
+// float64 once a key has been through a cursor, so every number is compared
+// as one.
+func compareKeys(a, b []interface{}, desc []bool) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		var cmp int
+		switch x := a[i].(type) {
+		case string:
+			y, _ := b[i].(string)
+			cmp = strings.Compare(x, y)
+		default:
+			cmp = compareFloats(toFloat(a[i]), toFloat(b[i]))
+		}
+		if i < len(desc) && desc[i] {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+// paginate picks the page of an already sorted list of n items. keyOf
+// returns the sort key of item i, which must be unique; desc gives the
+// direction of each key position. It sets the Link header and returns the
+// bounds of the page, or writes a 400 and returns false for a bad cursor.
+func paginate(w http.ResponseWriter, r *http.Request, n int, keyOf func(i int) []interface{}, desc []bool) (int, int, bool) {
+	limit, c, err := pageParams(r)
+	if err != nil {
+		invalid(w, err)
+		return 0, 0, false
+	}
+
+	start, end := 0, n
+	switch {
+	case c == nil:
+	case c.Dir == "next":
+		start = sort.Search(n, func(i int) bool { return compareKeys(keyOf(i), c.Keys, desc) > 0 })
+	default:
+		end = sort.Search(n, func(i int) bool { return compareKeys(keyOf(i), c.Keys, desc) >= 0 })
+	}
+	if c != nil && c.Dir == "prev" {
+		if end-start > limit {
+			start = end - limit
+		}
+	} else if end-start > limit {
+		end = start + limit
+	}
+
+	var next, prev *cursor
+	if end < n && end > 0 {
+		next = &cursor{Keys: keyOf(end - 1), Dir: "next"}
+	}
+	if start > 0 && start < n {
+		prev = &cursor{Keys: keyOf(start), Dir: "prev"}
+	}
+	setPageLinks(w, r, next, prev)
+	return start, end, true
+}
+
+// writePage writes the requested page of items, a slice already sorted by
+// the keys keyOf returns.
+func writePage(w http.ResponseWriter, r *http.Request, items interface{}, keyOf func(i int) []interface{}, desc []bool) {
+	list := reflect.ValueOf(items)
+	start, end, ok := paginate(w, r, list.Len(), keyOf, desc)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, list.Slice(start, end).Interface())
+}
+
+// micros turns a timestamp into a sort key that survives a cursor's trip
+// through JSON.
+func micros(t time.Time) float64 {
+	return float64(t.UnixNano() / 1000)
+}
+
+// entryKeys returns the sort key of ranked leaderboard entries: the score,
+// the tie-breakers of the chain and finally the student ID, matching the
+// order rankEntries produces.
+func entryKeys(entries []*models.LeaderboardEntry, lowerIsBetter bool, chain []string) (func(i int) []interface{}, []bool) {
+	desc := []bool{!lowerIsBetter}
+	for _, name := range chain {
+		desc = append(desc, name == models.TieBreakCredits || name == models.TieBreakUpdated)
+	}
+	desc = append(desc, false)
+
+	return func(i int) []interface{} {
+		entry := entries[i]
+		key := []interface{}{entry.Score}
+		for _, name := range chain {
+			switch name {
+			case models.TieBreakCredits:
+				key = append(key, float64(entry.Student.Credits))
+			case models.TieBreakUpdated:
+				key = append(key, micros(entry.Student.UpdatedAt))
+			case models.TieBreakLastName:
+				key = append(key, strings.ToLower(entry.Student.LastName))
+			case models.TieBreakStudentID:
+				key = append(key, entry.Student.ID)
+			}
+		}
+		return append(key, entry.Student.ID)
+	}, desc
+}
+
+// writeEntryPage writes one page of a ranked student leaderboard.
+func writeEntryPage(w http.ResponseWriter, r *http.Request, entries []*models.LeaderboardEntry, lowerIsBetter bool, chain []string) {
+	keyOf, desc := entryKeys(entries, lowerIsBetter, chain)
+	writePage(w, r, entries, keyOf, desc)
+}
+
+/******************************************************************************/
+
+// keysetCondition renders the SQL condition selecting the rows after (or,
+// for a prev cursor, before) the cursor's keys in the given sort.
+func keysetCondition(keys []sortKey, c *cursor, args *[]interface{}) string {
+	ors := make([]string, 0, len(keys))
+	for i, key := range keys {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, keys[j].field.column+" = "+keys[j].field.placeholder(c.Keys[j], args))
+		}
+		op := ">"
+		if key.desc != (c.Dir == "prev") {
+			op = "<"
+		}
+		ands = append(ands, key.field.column+" "+op+" "+key.field.placeholder(c.Keys[i], args))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")"
+}
+
+// reverseSort flips every key of a sort, for reading a prev page backwards.
+func reverseSort(keys []sortKey) []sortKey {
+	reversed := make([]sortKey, len(keys))
+	for i, key := range keys {
+		key.desc = !key.desc
+		reversed[i] = key
+	}
+	return reversed
+}
+
+// studentKey returns a student's values for the given sort keys.
+func studentKey(stu *models.Student, keys []sortKey) []interface{} {
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, key.field.value(stu))
+	}
+	return values
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"leaderboard-bk/cmd/models"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+		want   *cursor
+	}{
+		{"round trip", encodeCursor(&cursor{Keys: []interface{}{3.3, "smith", 42.0}, Dir: "next", Sort: "-gpa,last_name"}),
+			&cursor{Keys: []interface{}{3.3, "smith", 42.0}, Dir: "next", Sort: "-gpa,last_name"}},
+		{"prev", raw(`{"k":[7],"d":"prev"}`), &cursor{Keys: []interface{}{7.0}, Dir: "prev"}},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"k":[7],"d":"next"}`)), nil},
+		{"not base64", "not a cursor!", nil},
+		{"not json", raw("next 7"), nil},
+		{"unknown direction", raw(`{"k":[7],"d":"up"}`), nil},
+		{"no direction", raw(`{"k":[7]}`), nil},
+		{"no keys", raw(`{"k":[],"d":"next"}`), nil},
+		{"null key", raw(`{"k":[null],"d":"next"}`), nil},
+		{"boolean key", raw(`{"k":[true],"d":"next"}`), nil},
+		{"object key", raw(`{"k":[{"id":1}],"d":"next"}`), nil},
+		{"array key", raw(`{"k":[[1]],"d":"next"}`), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := decodeCursor(tt.cursor)
+			if tt.want == nil {
+				qe, ok := err.(*QueryError)
+				if !ok || qe.Param != "cursor" {
+					t.Fatalf("decodeCursor(%q) = %+v, %v, want a cursor error", tt.cursor, c, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeCursor(%q): %v", tt.cursor, err)
+			}
+			if !reflect.DeepEqual(c, tt.want) {
+				t.Errorf("decodeCursor(%q) = %+v, want %+v", tt.cursor, c, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		sort string
+		c    *cursor
+		sql  string
+		args []interface{}
+	}{
+		{"id only", "", &cursor{Keys: []interface{}{42.0}, Dir: "next"},
+			"((id > ?))", []interface{}{42.0}},
+		{"prev", "", &cursor{Keys: []interface{}{42.0}, Dir: "prev"},
+			"((id < ?))", []interface{}{42.0}},
+		{"descending", "-grad_year", &cursor{Keys: []interface{}{2027.0, 42.0}, Dir: "next"},
+			"((grad_year < ?) OR (grad_year = ? AND id > ?))", []interface{}{2027.0, 2027.0, 42.0}},
+		{"descending prev", "-grad_year", &cursor{Keys: []interface{}{2027.0, 42.0}, Dir: "prev"},
+			"((grad_year > ?) OR (grad_year = ? AND id < ?))", []interface{}{2027.0, 2027.0, 42.0}},
+		{"strings", "last_name,-id", &cursor{Keys: []interface{}{"smith", 42.0}, Dir: "next"},
+			"((lastName > ?) OR (lastName = ? AND id < ?))", []interface{}{"smith", "smith", 42.0}},
+		{"decimals", "-gpa,credits", &cursor{Keys: []interface{}{3.3, 12.5, 42.0}, Dir: "next"},
+			"((gpa < CAST(? AS DECIMAL(12, 2))) OR " +
+				"(gpa = CAST(? AS DECIMAL(12, 2)) AND credits > CAST(? AS DECIMAL(12, 1))) OR " +
+				"(gpa = CAST(? AS DECIMAL(12, 2)) AND credits = CAST(? AS DECIMAL(12, 1)) AND id > ?))",
+			[]interface{}{"3.30", "3.30", "12.5", "3.30", "12.5", 42.0}},
+		{"decimals rounded to scale", "gpa", &cursor{Keys: []interface{}{3.2999999523162842, 42.0}, Dir: "next"},
+			"((gpa > CAST(? AS DECIMAL(12, 2))) OR (gpa = CAST(? AS DECIMAL(12, 2)) AND id > ?))",
+			[]interface{}{"3.30", "3.30", 42.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := compileSort(tt.sort)
+			if err != nil {
+				t.Fatalf("compileSort(%q): %v", tt.sort, err)
+			}
+			var args []interface{}
+			if sql := keysetCondition(keys, tt.c, &args); sql != tt.sql {
+				t.Errorf("keysetCondition(%q) = %q, want %q", tt.sort, sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("keysetCondition(%q) args = %#v, want %#v", tt.sort, args, tt.args)
+			}
+		})
+	}
+}
+
+// A cursor taken from a student must survive its trip through JSON with
+// the keys the database compares it against.
+func TestStudentKeyThroughCursor(t *testing.T) {
+	keys, err := compileSort("-gpa,credits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stu := &models.Student{ID: 42, GPA: 3.3, Credits: 0.1}
+	c, err := decodeCursor(encodeCursor(&cursor{Keys: studentKey(stu, keys), Dir: "next"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{3.3, 0.1, 42.0}; !reflect.DeepEqual(c.Keys, want) {
+		t.Errorf("cursor keys = %#v, want %#v", c.Keys, want)
+	}
+	var args []interface{}
+	keysetCondition(keys, c, &args)
+	if want := []interface{}{"3.30", "3.30", "0.1", "3.30", "0.1", 42.0}; !reflect.DeepEqual(args, want) {
+		t.Errorf("keysetCondition args = %#v, want %#v", args, want)
+	}
+}
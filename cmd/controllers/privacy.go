@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultPrivacy applies to schools that have not chosen their own settings.
+var defaultPrivacy = models.PrivacySettings{
+	NameStyle:    models.NameStyleInitials,
+	BandWidth:    0.5,
+	MinGroupSize: 5,
+}
+
+func loadPrivacySettings(db *sql.DB, school int) (*models.PrivacySettings, error) {
+	settings := defaultPrivacy
+	err := db.QueryRow("SELECT name_style, band_width, min_group_size FROM leaderboard.public_settings WHERE school_id = ?",
+		school).Scan(&settings.NameStyle, &settings.BandWidth, &settings.MinGroupSize)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// loadStudentPrivacy returns the privacy choices matching the optional
+// where clause of the school's students who have made any, by student ID.
+func loadStudentPrivacy(db *sql.DB, school int, where string, args ...interface{}) (map[int]*models.StudentPrivacy, error) {
+	query := "SELECT student_id, opt_out, alias FROM leaderboard.student_privacy WHERE school_id = ?"
+	if where != "" {
+		query += " AND " + where
+	}
+	rows, err := db.Query(query, append([]interface{}{school}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	choices := make(map[int]*models.StudentPrivacy)
+	for rows.Next() {
+		choice := new(models.StudentPrivacy)
+		if err := rows.Scan(&choice.StudentID, &choice.OptOut, &choice.Alias); err != nil {
+			return nil, err
+		}
+		choices[choice.StudentID] = choice
+	}
+	return choices, rows.Err()
+}
+
+// publicName names a student on a public leaderboard: by their alias when
+// they have one, otherwise in the school's name style.
+func publicName(stu *models.Student, choice *models.StudentPrivacy, style string) string {
+	if choice != nil && choice.Alias != "" {
+		return choice.Alias
+	}
+	initial := func(name string) string {
+		r, _ := utf8.DecodeRuneInString(strings.TrimSpace(name))
+		if r == utf8.RuneError {
+			return ""
+		}
+		return string(unicode.ToUpper(r)) + "."
+	}
+	if style == models.NameStyleFirstName {
+		return strings.TrimSpace(strings.TrimSpace(stu.FirstName) + " " + initial(stu.LastName))
+	}
+	return strings.TrimSpace(initial(stu.FirstName) + " " + initial(stu.LastName))
+}
+
+// scoreBand returns the band of the given width holding a score.
+func scoreBand(score, width float64) *models.ScoreBand {
+	low := math.Floor(score/width) * width
+	return &models.ScoreBand{Low: roundTo(low, 3), High: roundTo(low+width, 3)}
+}
+
+// publicEntries drops the students who opted out. The rest keep their
+// official rank.
+func publicEntries(entries []*models.LeaderboardEntry, choices map[int]*models.StudentPrivacy) []*models.LeaderboardEntry {
+	shown := make([]*models.LeaderboardEntry, 0, len(entries))
+	for _, entry := range entries {
+		if choice := choices[entry.Student.ID]; choice == nil || !choice.OptOut {
+			shown = append(shown, entry)
+		}
+	}
+	return shown
+}
+
+// renderPublic turns entries into what the public sees. Bands are counted
+// over all of shown, not just the entries rendered, so that paging does not
+// change which bands are hidden.
+func renderPublic(page, shown []*models.LeaderboardEntry, settings *models.PrivacySettings,
+	choices map[int]*models.StudentPrivacy) []*models.PublicEntry {
+	sizes := make(map[models.ScoreBand]int)
+	for _, entry := range shown {
+		sizes[*scoreBand(entry.Score, settings.BandWidth)]++
+	}
+	rendered := make([]*models.PublicEntry, 0, len(page))
+	for _, entry := range page {
+		public := &models.PublicEntry{Rank: entry.Rank, Name: publicName(entry.Student, choices[entry.Student.ID], settings.NameStyle)}
+		if band := scoreBand(entry.Score, settings.BandWidth); sizes[*band] >= settings.MinGroupSize {
+			public.Band = band
+		}
+		rendered = append(rendered, public)
+	}
+	return rendered
+}
+
+/******************************************************************************/
+
+func FetchPrivacySettings(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	settings, err := loadPrivacySettings(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// UpdatePrivacySettings replaces the school's public leaderboard rules.
+// Only administrators may change them.
+func UpdatePrivacySettings(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	var settings models.PrivacySettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		invalid(w, err)
+		return
+	}
+	if settings.NameStyle != models.NameStyleInitials && settings.NameStyle != models.NameStyleFirstName {
+		invalidField(w, "name_style", "must be initials or first_name")
+		return
+	}
+	if settings.BandWidth <= 0 {
+		invalidField(w, "band_width", "must be positive")
+		return
+	}
+	if settings.MinGroupSize < 1 {
+		invalidField(w, "min_group_size", "must be at least 1")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO leaderboard.public_settings(school_id, name_style, band_width, min_group_size) VALUES(?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE name_style = VALUES(name_style), band_width = VALUES(band_width), min_group_size = VALUES(min_group_size)",
+		tenant.SchoolID, settings.NameStyle, settings.BandWidth, settings.MinGroupSize); err != nil {
+		serverError(w, err)
+		return
+	}
+	log.Println("PRIVACY SETTINGS: By: " + tenant.Username)
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func FetchStudentPrivacy(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	id, err := strconv.Atoi(mux.Vars(r)["studentId"])
+	if err != nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	school := tenantOf(r).SchoolID
+	if ok, err := studentInSchool(db, school, id); err != nil || !ok {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	choices, err := loadStudentPrivacy(db, school, "student_id = ?", id)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	choice := choices[id]
+	if choice == nil {
+		choice = &models.StudentPrivacy{StudentID: id}
+	}
+	writeJSON(w, http.StatusOK, choice)
+}
+
+// aliasFault says what is wrong with a student's public alias, or returns
+// "". An alias is plain text, and must not give away the name it stands
+// in for.
+func aliasFault(alias string, stu *models.Student) string {
+	if utf8.RuneCountInString(alias) > 64 {
+		return "must be at most 64 characters"
+	}
+	for _, r := range alias {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" .-'", r) {
+			return "may only contain letters, digits, spaces, dots, hyphens and apostrophes"
+		}
+	}
+	words := make(map[string]bool)
+	for _, word := range foldName(alias) {
+		words[word] = true
+	}
+	for _, word := range foldName(stu.FirstName + " " + stu.LastName) {
+		if len([]rune(word)) > 1 && words[word] {
+			return "must not contain the student's name"
+		}
+	}
+	return ""
+}
+
+// UpdateStudentPrivacy records whether a student opts out of public
+// leaderboards and the alias they are shown under. Only administrators may
+// change them, as they may change the school's settings.
+func UpdateStudentPrivacy(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	id, err := strconv.Atoi(mux.Vars(r)["studentId"])
+	if err != nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	school := tenant.SchoolID
+	stus, err := loadStudents(db, "id = ? AND school_id = ?", id, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(stus) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	var choice models.StudentPrivacy
+	if err := json.NewDecoder(r.Body).Decode(&choice); err != nil {
+		invalid(w, err)
+		return
+	}
+	choice.StudentID = id
+	choice.Alias = strings.TrimSpace(choice.Alias)
+	if fault := aliasFault(choice.Alias, stus[0]); fault != "" {
+		invalidField(w, "alias", fault)
+		return
+	}
+	if _, err := db.Exec("INSERT INTO leaderboard.student_privacy(student_id, school_id, opt_out, alias) VALUES(?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE opt_out = VALUES(opt_out), alias = VALUES(alias)",
+		id, school, choice.OptOut, choice.Alias); err != nil {
+		serverError(w, err)
+		return
+	}
+	log.Println("STUDENT PRIVACY: " + strconv.Itoa(id) + " | By: " + tenant.Username)
+	writeJSON(w, http.StatusOK, choice)
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"leaderboard-bk/cmd/models"
+	"strings"
+	"testing"
+)
+
+func TestAliasFault(t *testing.T) {
+	stu := &models.Student{FirstName: "José", LastName: "García"}
+	const (
+		tooLong    = "must be at most 64 characters"
+		characters = "may only contain letters, digits, spaces, dots, hyphens and apostrophes"
+		name       = "must not contain the student's name"
+	)
+	tests := []struct {
+		name  string
+		alias string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"plain", "Runner 7", ""},
+		{"punctuation", "J. O'Neil-Smith", ""},
+		{"non-latin letters", "Δρομέας", ""},
+		{"64 multi-byte characters", strings.Repeat("é", 64), ""},
+		{"65 characters", strings.Repeat("a", 65), tooLong},
+		{"at sign", "runner@school", characters},
+		{"markup", "<b>fast</b>", characters},
+		{"underscore", "fast_runner", characters},
+		{"first name", "Fast Jose", name},
+		{"last name", "garcía the great", name},
+		{"folded case and accents", "JOSE", name},
+		{"name inside a hyphenated alias", "Team-Garcia", name},
+		// Initials alone do not give the name away.
+		{"initials", "J G", ""},
+		{"name as part of a word", "Josephine", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aliasFault(tt.alias, stu); got != tt.want {
+				t.Errorf("aliasFault(%q) = %q, want %q", tt.alias, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+var errWrongStatus = errors.New("publication is not in a state that allows this")
+
+// canPublish reports whether the caller may approve publications.
+func canPublish(tenant *models.Tenant) bool {
+	return tenant.Role == models.RoleAdmin || tenant.Role == models.RolePublisher
+}
+
+// loadPublications returns the school's publications matching the where
+// clause, newest first. Entries are only read when asked for.
+func loadPublications(db *sql.DB, school int, withEntries bool, where string, args ...interface{}) ([]*models.Publication, error) {
+	columns := "id, status, note, definition, created_by, created_at, approved_by, published_at"
+	if withEntries {
+		columns += ", entries"
+	}
+	rows, err := db.Query("SELECT "+columns+" FROM leaderboard.publications WHERE school_id = ? AND "+where+" ORDER BY id DESC",
+		append([]interface{}{school}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pubs := make([]*models.Publication, 0)
+	for rows.Next() {
+		pub := new(models.Publication)
+		var definition, entries string
+		var approvedBy sql.NullString
+		var createdAt, publishedAt mysql.NullTime
+		dest := []interface{}{&pub.ID, &pub.Status, &pub.Note, &definition, &pub.CreatedBy, &createdAt, &approvedBy, &publishedAt}
+		if withEntries {
+			dest = append(dest, &entries)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		pub.CreatedAt, pub.ApprovedBy = createdAt.Time, approvedBy.String
+		if publishedAt.Valid {
+			pub.PublishedAt = &publishedAt.Time
+		}
+		pub.Board = new(models.Leaderboard)
+		if err := json.Unmarshal([]byte(definition), pub.Board); err != nil {
+			return nil, err
+		}
+		if withEntries {
+			if err := json.Unmarshal([]byte(entries), &pub.Entries); err != nil {
+				return nil, err
+			}
+		}
+		pubs = append(pubs, pub)
+	}
+	return pubs, rows.Err()
+}
+
+// requestedPublication loads the publication named by the route, with its
+// entries, or nil.
+func requestedPublication(db *sql.DB, r *http.Request) (*models.Publication, error) {
+	vars := mux.Vars(r)
+	pubs, err := loadPublications(db, tenantOf(r).SchoolID, true, "board = ? AND id = ?", vars["slug"], vars["publicationId"])
+	if err != nil || len(pubs) == 0 {
+		return nil, err
+	}
+	return pubs[0], nil
+}
+
+// publish makes a publication the board's published one, retiring the
+// current one. The publication must have one of the given statuses.
+func publish(db *sql.DB, school int, pub *models.Publication, by string, from ...string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{by, pub.ID, school}
+	for _, status := range from {
+		args = append(args, status)
+	}
+	res, err := tx.Exec("UPDATE leaderboard.publications SET status = 'published', approved_by = ?, published_at = NOW() "+
+		"WHERE id = ? AND school_id = ? AND status IN (?"+repeatPlaceholders(len(from)-1)+")", args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errWrongStatus
+	}
+	if _, err := tx.Exec("UPDATE leaderboard.publications SET status = 'retired' "+
+		"WHERE school_id = ? AND board = ? AND status = 'published' AND id <> ?", school, pub.Board.Slug, pub.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writePublicationChange answers a status change: the publication as it
+// now stands, 409 when its status did not allow the change.
+func writePublicationChange(w http.ResponseWriter, r *http.Request, db *sql.DB, err error) {
+	if err == errWrongStatus {
+		problem(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	pub, err := requestedPublication(db, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	pub.Entries = nil
+	writeJSON(w, http.StatusOK, pub)
+}
+
+/******************************************************************************/
+
+// IndexPublications lists a board's publications, optionally with one
+// `status`. Only signed-in staff see drafts and history.
+func IndexPublications(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Username == "" {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	where := "board = ?"
+	args := []interface{}{mux.Vars(r)["slug"]}
+	if status := r.URL.Query().Get("status"); status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+	pubs, err := loadPublications(db, tenant.SchoolID, false, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, pubs, func(i int) []interface{} { return []interface{}{pubs[i].ID} }, []bool{true})
+}
+
+// CreatePublication computes a board into a new draft for review. The body
+// may carry a `note` for the reviewer.
+func CreatePublication(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Username == "" {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		invalid(w, err)
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	board, err := resolveBoard(db, tenant.SchoolID, mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if board == nil || !canView(tenant, board) {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	entries, err := computeBoard(db, tenant.SchoolID, board)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	definition, _ := json.Marshal(board)
+	frozen, _ := json.Marshal(entries)
+	res, err := db.Exec("INSERT INTO leaderboard.publications(school_id, board, note, definition, entries, created_by) "+
+		"VALUES(?, ?, ?, ?, ?, ?)", tenant.SchoolID, board.Slug, strings.TrimSpace(body.Note), string(definition), string(frozen),
+		tenant.Username)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	log.Println("DRAFT PUBLICATION: " + board.Slug + " | By: " + tenant.Username)
+
+	pubs, err := loadPublications(db, tenant.SchoolID, true, "id = ?", id)
+	if err != nil || len(pubs) == 0 {
+		log.Println("DRAFT PUBLICATION: reading back draft failed")
+		problem(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusCreated, pubs[0])
+}
+
+// FetchPublication serves a publication with its frozen standings, for
+// review.
+func FetchPublication(w http.ResponseWriter, r *http.Request) {
+	if tenantOf(r).Username == "" {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	pub, err := requestedPublication(db, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if pub == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	keyOf, desc := entryKeys(pub.Entries, pub.Board.Direction == "asc", pub.Board.TieBreakers)
+	start, end, ok := paginate(w, r, len(pub.Entries), keyOf, desc)
+	if !ok {
+		return
+	}
+	pub.Entries = pub.Entries[start:end]
+	writeJSON(w, http.StatusOK, pub)
+}
+
+// ApprovePublication publishes a draft. Only publishers and administrators
+// may approve.
+func ApprovePublication(w http.ResponseWriter, r *http.Request) {
+	changePublication(w, r, "APPROVE", true, func(db *sql.DB, tenant *models.Tenant, pub *models.Publication) error {
+		return publish(db, tenant.SchoolID, pub, tenant.Username, models.PublicationDraft)
+	})
+}
+
+// RollbackPublication publishes a previously published publication again,
+// retiring the current one.
+func RollbackPublication(w http.ResponseWriter, r *http.Request) {
+	changePublication(w, r, "ROLLBACK", true, func(db *sql.DB, tenant *models.Tenant, pub *models.Publication) error {
+		return publish(db, tenant.SchoolID, pub, tenant.Username, models.PublicationRetired)
+	})
+}
+
+// DiscardPublication drops a draft that should not be published.
+func DiscardPublication(w http.ResponseWriter, r *http.Request) {
+	changePublication(w, r, "DISCARD", false, func(db *sql.DB, tenant *models.Tenant, pub *models.Publication) error {
+		res, err := db.Exec("UPDATE leaderboard.publications SET status = 'discarded' WHERE id = ? AND status = 'draft'", pub.ID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errWrongStatus
+		}
+		return nil
+	})
+}
+
+// changePublication applies a status change to the publication named by
+// the route. Changes needing approval are limited to publishers.
+func changePublication(w http.ResponseWriter, r *http.Request, action string, approval bool,
+	change func(db *sql.DB, tenant *models.Tenant, pub *models.Publication) error) {
+	tenant := tenantOf(r)
+	if tenant.Username == "" || (approval && !canPublish(tenant)) {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	pub, err := requestedPublication(db, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if pub == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	err = change(db, tenant, pub)
+	if err == nil {
+		log.Println(action + " PUBLICATION: " + pub.Board.Slug + " | By: " + tenant.Username)
+	}
+	writePublicationChange(w, r, db, err)
+}
+
+/******************************************************************************/
+
+// PublicLeaderboard serves the published standings of a public board. It
+// never computes standings, so edits are not seen until they are approved,
+// and it follows the school's privacy settings: students who opted out are
+// left out, names are shortened and scores are shown as bands.
+func PublicLeaderboard(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	school := tenantOf(r).SchoolID
+	board, err := resolveBoard(db, school, mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if board == nil || board.Visibility != models.VisibilityPublic {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	pubs, err := loadPublications(db, school, true, "board = ? AND status = 'published'", board.Slug)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(pubs) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	settings, err := loadPrivacySettings(db, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	choices, err := loadStudentPrivacy(db, school, "")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	pub := pubs[0]
+	shown := publicEntries(pub.Entries, choices)
+	if len(shown) < settings.MinGroupSize {
+		problem(w, http.StatusNotFound, "too few students to show this board publicly")
+		return
+	}
+	keyOf, desc := entryKeys(shown, pub.Board.Direction == "asc", pub.Board.TieBreakers)
+	start, end, ok := paginate(w, r, len(shown), keyOf, desc)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, &models.PublicStandings{
+		Slug:        pub.Board.Slug,
+		Name:        pub.Board.Name,
+		PublishedAt: pub.PublishedAt,
+		Entries:     renderPublic(shown[start:end], shown, settings, choices),
+	})
+}
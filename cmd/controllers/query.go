@@ -0,0 +1,526 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"leaderboard-bk/cmd/models"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The listing query language. A filter such as
+//
+//	gpa >= 3.5 and sport in ("soccer", "track") and not last_name = "Smith"
+//
+// compiles both to a parameterised SQL condition, for listings read straight
+// from MySQL, and to an in-memory predicate, for boards computed in Go. Only
+// the fields below may be named, so user input never reaches the SQL text.
+
+// A field the query language may filter and sort on.
+type queryField struct {
+	column  string
+	numeric bool
+	// The scale of a DECIMAL column. Its values are read into float32s, so
+	// they are rounded back to the scale, and compared in SQL as decimals,
+	// for the keys of a cursor to match the rows they came from.
+	decimals int
+	value    func(*models.Student) interface{}
+}
+
+var queryFields = map[string]*queryField{
+	"id":          {"id", true, 0, func(s *models.Student) interface{} { return float64(s.ID) }},
+	"first_name":  {"firstName", false, 0, func(s *models.Student) interface{} { return s.FirstName }},
+	"last_name":   {"lastName", false, 0, func(s *models.Student) interface{} { return s.LastName }},
+	"gpa":         {"gpa", true, 2, func(s *models.Student) interface{} { return roundTo(float64(s.GPA), 2) }},
+	"credits":     {"credits", true, 1, func(s *models.Student) interface{} { return roundTo(float64(s.Credits), 1) }},
+	"sport":       {"sport", false, 0, func(s *models.Student) interface{} { return s.Sport }},
+	"grad_year":   {"grad_year", true, 0, func(s *models.Student) interface{} { return float64(s.GradYear) }},
+	"grade_level": {"grade_level", true, 0, func(s *models.Student) interface{} { return float64(s.GradeLevel) }},
+}
+
+// placeholder renders a parameter compared with the field's column, and
+// adds its argument: decimals are passed as exact decimal text.
+func (f *queryField) placeholder(v interface{}, args *[]interface{}) string {
+	if n, ok := v.(float64); ok && f.decimals > 0 {
+		*args = append(*args, strconv.FormatFloat(n, 'f', f.decimals, 64))
+		return "CAST(? AS DECIMAL(12, " + strconv.Itoa(f.decimals) + "))"
+	}
+	*args = append(*args, v)
+	return "?"
+}
+
+// QueryError reports a malformed query parameter, such as a filter, sort or
+// fields, or an invalid field of a request body.
+type QueryError struct {
+	Param string
+	Pos   int
+	Msg   string
+}
+
+func (e *QueryError) Error() string {
+	if e.Pos < 0 {
+		return e.Param + ": " + e.Msg
+	}
+	return fmt.Sprintf("%s: %s at position %d", e.Param, e.Msg, e.Pos+1)
+}
+
+/******************************************************************************/
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexQuery(s string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			var b strings.Builder
+			for i++; i < len(runes) && runes[i] != c; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, &QueryError{"filter", start, "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{tokString, b.String(), start})
+		case strings.ContainsRune("=!<>", c):
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, &QueryError{"filter", start, "expected != "}
+			}
+			tokens = append(tokens, token{tokOp, op, start})
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			start := i
+			for i++; i < len(runes) && (runes[i] == '.' || unicode.IsDigit(runes[i])); i++ {
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i++; i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])); i++ {
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i]), start})
+		default:
+			return nil, &QueryError{"filter", i, "unexpected character " + strconv.QuoteRune(c)}
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(runes)}), nil
+}
+
+/******************************************************************************/
+
+// queryNode is a compiled filter expression.
+type queryNode interface {
+	// sql appends the node's parameters to args and returns its condition.
+	sql(args *[]interface{}) string
+	match(stu *models.Student) bool
+}
+
+type logicalNode struct {
+	op          string
+	left, right queryNode
+}
+
+func (n *logicalNode) sql(args *[]interface{}) string {
+	return "(" + n.left.sql(args) + " " + strings.ToUpper(n.op) + " " + n.right.sql(args) + ")"
+}
+
+func (n *logicalNode) match(stu *models.Student) bool {
+	if n.op == "and" {
+		return n.left.match(stu) && n.right.match(stu)
+	}
+	return n.left.match(stu) || n.right.match(stu)
+}
+
+type notNode struct {
+	inner queryNode
+}
+
+func (n *notNode) sql(args *[]interface{}) string {
+	return "NOT (" + n.inner.sql(args) + ")"
+}
+
+func (n *notNode) match(stu *models.Student) bool {
+	return !n.inner.match(stu)
+}
+
+type compareNode struct {
+	field  *queryField
+	op     string
+	values []interface{}
+}
+
+func (n *compareNode) sql(args *[]interface{}) string {
+	*args = append(*args, n.values...)
+	if n.op == "in" {
+		return n.field.column + " IN (?" + repeatPlaceholders(len(n.values)-1) + ")"
+	}
+	return n.field.column + " " + n.op + " ?"
+}
+
+// match mirrors MySQL's default case-insensitive collation for strings.
+func (n *compareNode) match(stu *models.Student) bool {
+	v := n.field.value(stu)
+	for _, want := range n.values {
+		cmp := compareValues(v, want)
+		switch n.op {
+		case "in", "=":
+			if cmp == 0 {
+				return true
+			}
+		case "!=":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		case ">=":
+			return cmp >= 0
+		}
+	}
+	return false
+}
+
+func compareValues(a, b interface{}) int {
+	if x, ok := a.(float64); ok {
+		y, _ := b.(float64)
+		return compareFloats(x, y)
+	}
+	x, _ := a.(string)
+	y, _ := b.(string)
+	return strings.Compare(strings.ToLower(x), strings.ToLower(y))
+}
+
+/******************************************************************************/
+
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) fail(t token, msg string) error {
+	if t.kind == tokEOF {
+		return &QueryError{"filter", t.pos, msg + ", found end of filter"}
+	}
+	return &QueryError{"filter", t.pos, msg + ", found " + strconv.Quote(t.text)}
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.keyword("or") {
+		var right queryNode
+		if right, err = p.parseAnd(); err == nil {
+			left = &logicalNode{"or", left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.keyword("and") {
+		var right queryNode
+		if right, err = p.parseUnary(); err == nil {
+			left = &logicalNode{"and", left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.keyword("not") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{inner}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, p.fail(t, "expected )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, p.fail(t, "expected a field name")
+	}
+	field, ok := queryFields[strings.ToLower(t.text)]
+	if !ok {
+		return nil, &QueryError{"filter", t.pos, "unknown field " + strconv.Quote(t.text) + "; expected one of " + strings.Join(queryFieldNames(), ", ")}
+	}
+
+	negate := p.keyword("not")
+	if p.keyword("in") {
+		if t := p.next(); t.kind != tokLParen {
+			return nil, p.fail(t, "expected ( after in")
+		}
+		node := &compareNode{field: field, op: "in"}
+		for {
+			v, err := p.parseValue(field)
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, v)
+			t := p.next()
+			if t.kind == tokRParen {
+				break
+			}
+			if t.kind != tokComma {
+				return nil, p.fail(t, "expected , or )")
+			}
+		}
+		if negate {
+			return &notNode{node}, nil
+		}
+		return node, nil
+	}
+	if negate {
+		return nil, p.fail(p.peek(), "expected in after not")
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, p.fail(op, "expected a comparison operator")
+	}
+	if op.text == "==" {
+		op.text = "="
+	}
+	v, err := p.parseValue(field)
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{field: field, op: op.text, values: []interface{}{v}}, nil
+}
+
+func (p *queryParser) parseValue(field *queryField) (interface{}, error) {
+	t := p.next()
+	switch {
+	case field.numeric && t.kind == tokNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &QueryError{"filter", t.pos, "malformed number " + strconv.Quote(t.text)}
+		}
+		return v, nil
+	case !field.numeric && (t.kind == tokString || t.kind == tokIdent):
+		return t.text, nil
+	case field.numeric:
+		return nil, p.fail(t, "expected a number")
+	}
+	return nil, p.fail(t, "expected a quoted string")
+}
+
+func queryFieldNames() []string {
+	names := make([]string, 0, len(queryFields))
+	for name := range queryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compileFilter parses a filter expression. An empty filter matches
+// everything and compiles to nil.
+func compileFilter(s string) (queryNode, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.fail(t, "expected and, or or the end of the filter")
+	}
+	return node, nil
+}
+
+/******************************************************************************/
+
+// A sort key from the `sort` parameter.
+type sortKey struct {
+	name  string
+	field *queryField
+	desc  bool
+}
+
+// compileSort parses a sort such as "-gpa,last_name". The result always ends
+// with the student ID so that the order is total.
+func compileSort(s string) ([]sortKey, error) {
+	keys := make([]sortKey, 0)
+	seen := make(map[string]bool)
+	if strings.TrimSpace(s) != "" {
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			key := sortKey{}
+			if strings.HasPrefix(part, "-") {
+				key.desc = true
+				part = part[1:]
+			} else {
+				part = strings.TrimPrefix(part, "+")
+			}
+			key.name = strings.ToLower(part)
+			field, ok := queryFields[key.name]
+			if !ok {
+				return nil, &QueryError{"sort", -1, "unknown field " + strconv.Quote(part)}
+			}
+			if seen[key.name] {
+				return nil, &QueryError{"sort", -1, "field " + strconv.Quote(part) + " appears twice"}
+			}
+			seen[key.name] = true
+			key.field = field
+			keys = append(keys, key)
+		}
+	}
+	if !seen["id"] {
+		keys = append(keys, sortKey{name: "id", field: queryFields["id"]})
+	}
+	return keys, nil
+}
+
+// orderBy renders sort keys as an SQL ORDER BY list.
+func orderBy(keys []sortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		dir := " ASC"
+		if key.desc {
+			dir = " DESC"
+		}
+		parts = append(parts, key.field.column+dir)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// selectFields parses the `fields` parameter into JSON keys of a student.
+// An empty parameter selects every field and returns nil.
+func selectFields(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	for _, key := range studentJSONKeys() {
+		known[key] = true
+	}
+	fields := make([]string, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if !known[part] {
+			return nil, &QueryError{"fields", -1, "unknown field " + strconv.Quote(part) + "; expected one of " + strings.Join(studentJSONKeys(), ", ")}
+		}
+		fields = append(fields, part)
+	}
+	return fields, nil
+}
+
+// studentJSONKeys lists the keys a student may have in JSON, read from the
+// struct's tags so that fields left out when empty are included.
+func studentJSONKeys() []string {
+	t := reflect.TypeOf(models.Student{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// project returns the student with only the selected fields, or the student
+// itself when fields is nil.
+func project(stu *models.Student, fields []string) interface{} {
+	if fields == nil {
+		return stu
+	}
+	var all map[string]interface{}
+	bytes, _ := json.Marshal(stu)
+	_ = json.Unmarshal(bytes, &all)
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		selected[field] = all[field]
+	}
+	return selected
+}
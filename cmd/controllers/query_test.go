@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"leaderboard-bk/cmd/models"
+	"reflect"
+	"testing"
+)
+
+func TestCompileFilterSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		sql    string
+		args   []interface{}
+	}{
+		{"empty", "  ", "", nil},
+		{"comparison", "gpa >= 3.5", "gpa >= ?", []interface{}{3.5}},
+		{"double equals", "grad_year == 2026", "grad_year = ?", []interface{}{2026.0}},
+		{"field names ignore case", "Last_Name != smith", "lastName != ?", []interface{}{"smith"}},
+		{"and binds tighter than or", "sport = a or sport = b and gpa > 3",
+			"(sport = ? OR (sport = ? AND gpa > ?))", []interface{}{"a", "b", 3.0}},
+		{"left associative", "id = 1 and id = 2 and id = 3",
+			"((id = ? AND id = ?) AND id = ?)", []interface{}{1.0, 2.0, 3.0}},
+		{"parentheses", "(sport = a or sport = b) and gpa > 3",
+			"((sport = ? OR sport = ?) AND gpa > ?)", []interface{}{"a", "b", 3.0}},
+		{"not binds tightest", "not gpa > 3 and credits < 10",
+			"(NOT (gpa > ?) AND credits < ?)", []interface{}{3.0, 10.0}},
+		{"keywords ignore case", "NOT (gpa > 3 OR gpa < 1)",
+			"NOT ((gpa > ? OR gpa < ?))", []interface{}{3.0, 1.0}},
+		{"in", `sport in ("soccer", 'track')`, "sport IN (?, ?)", []interface{}{"soccer", "track"}},
+		{"not in", "grade_level not in (9)", "NOT (grade_level IN (?))", []interface{}{9.0}},
+		{"double quotes", `last_name = "O'Brien"`, "lastName = ?", []interface{}{"O'Brien"}},
+		{"single quotes", `last_name = 'Say "hi"'`, "lastName = ?", []interface{}{`Say "hi"`}},
+		{"escaped quote", `last_name = 'O\'Brien'`, "lastName = ?", []interface{}{"O'Brien"}},
+		{"operators in strings", `sport = "a) or (1 = 1"`, "sport = ?", []interface{}{"a) or (1 = 1"}},
+		{"negative number", "credits > -1.5", "credits > ?", []interface{}{-1.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := compileFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("compileFilter(%q): %v", tt.filter, err)
+			}
+			if node == nil {
+				if tt.sql != "" {
+					t.Fatalf("compileFilter(%q) = nil, want %q", tt.filter, tt.sql)
+				}
+				return
+			}
+			var args []interface{}
+			if sql := node.sql(&args); sql != tt.sql {
+				t.Errorf("compileFilter(%q) sql = %q, want %q", tt.filter, sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("compileFilter(%q) args = %#v, want %#v", tt.filter, args, tt.args)
+			}
+		})
+	}
+}
+
+func TestCompileFilterRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		pos    int
+		msg    string
+	}{
+		{"unknown field", "password = 1", 0,
+			`unknown field "password"; expected one of credits, first_name, gpa, grad_year, grade_level, id, last_name, sport`},
+		{"column name", "firstName = a", 0,
+			`unknown field "firstName"; expected one of credits, first_name, gpa, grad_year, grade_level, id, last_name, sport`},
+		{"bare bang", "gpa ! 3", 4, "expected != "},
+		{"word operator", "sport like a", 6, `expected a comparison operator, found "like"`},
+		{"sql operator", "gpa <> 3", 5, `expected a number, found ">"`},
+		{"unexpected character", "gpa = 3; drop table students", 7, "unexpected character ';'"},
+		{"comment", "gpa = 3 -- x", 8, `expected and, or or the end of the filter, found "-"`},
+		{"unterminated string", `sport = "soccer`, 8, "unterminated string"},
+		{"string for number", "gpa = '3'", 6, `expected a number, found "3"`},
+		{"number for string", "sport = 3", 8, `expected a quoted string, found "3"`},
+		{"malformed number", "gpa = 3.5.1", 6, `malformed number "3.5.1"`},
+		{"missing value", "gpa >", 5, "expected a number, found end of filter"},
+		{"unclosed parenthesis", "(gpa > 3", 8, "expected ), found end of filter"},
+		{"not without in", "sport not = a", 10, `expected in after not, found "="`},
+		{"in without list", "sport in a", 9, `expected ( after in, found "a"`},
+		{"trailing value", "gpa > 3 4", 8, `expected and, or or the end of the filter, found "4"`},
+		{"dangling and", "gpa > 3 and", 11, "expected a field name, found end of filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := compileFilter(tt.filter)
+			if err == nil {
+				var args []interface{}
+				t.Fatalf("compileFilter(%q) = %q, want an error", tt.filter, node.sql(&args))
+			}
+			qe, ok := err.(*QueryError)
+			if !ok {
+				t.Fatalf("compileFilter(%q) error = %#v, want a *QueryError", tt.filter, err)
+			}
+			if qe.Param != "filter" || qe.Pos != tt.pos || qe.Msg != tt.msg {
+				t.Errorf("compileFilter(%q) error = %+v, want {filter %d %s}", tt.filter, *qe, tt.pos, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCompileFilterMatch(t *testing.T) {
+	stu := &models.Student{ID: 7, FirstName: "Ana", LastName: "O'Brien", GPA: 3.75, Credits: 12.5,
+		Sport: "Soccer", GradYear: 2027, GradeLevel: 11}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"gpa >= 3.75", true},
+		{"gpa > 3.75", false},
+		{"credits = 12.5", true},
+		{`sport = "soccer"`, true},
+		{"sport in (track, SOCCER)", true},
+		{"sport not in (track, soccer)", false},
+		{"last_name < p", true},
+		{"not grade_level = 11", false},
+		{"grade_level = 12 or gpa > 3 and sport = soccer", true},
+		{"(grade_level = 12 or gpa > 3) and sport = track", false},
+		{"id != 7 or first_name = ana", true},
+	}
+	for _, tt := range tests {
+		node, err := compileFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("compileFilter(%q): %v", tt.filter, err)
+		}
+		if got := node.match(stu); got != tt.want {
+			t.Errorf("compileFilter(%q).match = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCompileSort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		orderBy string
+		err     string
+	}{
+		{"", "id ASC", ""},
+		{"-gpa, last_name", "gpa DESC, lastName ASC, id ASC", ""},
+		{"+Credits,-id", "credits ASC, id DESC", ""},
+		{"gpa,-gpa", "", `sort: field "gpa" appears twice`},
+		{"t_stamp", "", `sort: unknown field "t_stamp"`},
+		{"gpa desc", "", `sort: unknown field "gpa desc"`},
+	}
+	for _, tt := range tests {
+		keys, err := compileSort(tt.sort)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("compileSort(%q) error = %v, want %s", tt.sort, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("compileSort(%q): %v", tt.sort, err)
+		case orderBy(keys) != tt.orderBy:
+			t.Errorf("compileSort(%q) = %q, want %q", tt.sort, orderBy(keys), tt.orderBy)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	tests := []struct {
+		fields string
+		want   []string
+		err    string
+	}{
+		{"", nil, ""},
+		{"id, last_name", []string{"id", "last_name"}, ""},
+		{"grad_year,grade_level", []string{"grad_year", "grade_level"}, ""},
+		{"archived_at", []string{"archived_at"}, ""},
+		{"lastName", nil, `fields: unknown field "lastName"; expected one of archived_at, credits, first_name, gpa, ` +
+			`grad_year, grade_level, id, last_name, school_id, sport, t_stamp, updated_at`},
+	}
+	for _, tt := range tests {
+		fields, err := selectFields(tt.fields)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("selectFields(%q) error = %v, want %s", tt.fields, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("selectFields(%q): %v", tt.fields, err)
+		case !reflect.DeepEqual(fields, tt.want):
+			t.Errorf("selectFields(%q) = %q, want %q", tt.fields, fields, tt.want)
+		}
+	}
+}
+
+// Fields left out of a student's JSON when empty are still selected, as
+// null.
+func TestProjectEmptyFields(t *testing.T) {
+	got := project(&models.Student{ID: 7, GradYear: 2027}, []string{"id", "grad_year", "archived_at"})
+	want := map[string]interface{}{"id": 7.0, "grad_year": 2027.0, "archived_at": nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("project = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// Rank histories are downsampled to this many points unless `points` says
+// otherwise.
+const (
+	defaultHistoryPoints = 100
+	maxHistoryPoints     = 1000
+)
+
+// Rows written to the rank history per INSERT.
+const historyBatch = 500
+
+// How long recording a board waits for another server recording it.
+const historyLockWait = 30 * time.Second
+
+type recordedRank struct {
+	rank  int
+	score float64
+}
+
+// latestRanks returns each student's most recently recorded rank on a board.
+func latestRanks(ctx context.Context, conn *sql.Conn, school int, board string) (map[int]recordedRank, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT h.student_id, h.rank_position, h.score FROM leaderboard.rank_history h "+
+		"JOIN (SELECT MAX(id) AS id FROM leaderboard.rank_history WHERE school_id = ? AND board = ? GROUP BY student_id) latest "+
+		"ON latest.id = h.id", school, board)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[int]recordedRank)
+	for rows.Next() {
+		var studentID int
+		var rank recordedRank
+		if err := rows.Scan(&studentID, &rank.rank, &rank.score); err != nil {
+			return nil, err
+		}
+		latest[studentID] = rank
+	}
+	return latest, rows.Err()
+}
+
+// recordRanks adds a freshly computed board to the rank history. For a
+// snapshot every entry is written; otherwise only the students whose rank
+// or score has moved since their last recorded point. Recording a board
+// holds a lock named after it, so that two servers recording the same
+// standings at once cannot both write them.
+func recordRanks(db *sql.DB, school int, board string, entries []*models.LeaderboardEntry, snapshotID int) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	lock := "leaderboard.rank_history." + strconv.Itoa(school) + "." + board
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lock, int(historyLockWait/time.Second)).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return errors.New("timed out waiting to record " + board)
+	}
+	defer conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", lock)
+
+	var latest map[int]recordedRank
+	if snapshotID == 0 {
+		if latest, err = latestRanks(ctx, conn, school, board); err != nil {
+			return err
+		}
+	}
+	var snapshot interface{}
+	if snapshotID != 0 {
+		snapshot = snapshotID
+	}
+
+	changed := make([]*models.LeaderboardEntry, 0)
+	for _, entry := range entries {
+		if last, ok := latest[entry.Student.ID]; !ok || last.rank != entry.Rank || last.score != entry.Score {
+			changed = append(changed, entry)
+		}
+	}
+	for len(changed) > 0 {
+		batch := changed
+		if len(batch) > historyBatch {
+			batch = batch[:historyBatch]
+		}
+		changed = changed[len(batch):]
+
+		args := make([]interface{}, 0, 6*len(batch))
+		for _, entry := range batch {
+			args = append(args, school, board, entry.Student.ID, entry.Rank, entry.Score, snapshot)
+		}
+		values := "(?, ?, ?, ?, ?, ?)"
+		for i := 1; i < len(batch); i++ {
+			values += ", (?, ?, ?, ?, ?, ?)"
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO leaderboard.rank_history(school_id, board, student_id, rank_position, score, snapshot_id) "+
+			"VALUES "+values, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordBoards records every board the school has. A snapshotID of 0
+// records only what moved.
+func recordBoards(db *sql.DB, school int, snapshotID int) error {
+	boards, err := schoolBoards(db, school)
+	if err != nil {
+		return err
+	}
+	for _, board := range boards {
+		entries, err := computeBoard(db, school, board)
+		if err != nil {
+			return err
+		}
+		if err := recordRanks(db, school, board.Slug, entries, snapshotID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schools whose history is being recorded, and whether they changed again
+// meanwhile.
+var historyQueue = struct {
+	sync.Mutex
+	again map[int]bool
+}{again: make(map[int]bool)}
+
+// historyChanged records the school's boards after a change, in the
+// background. Changes that come in while it is recording are folded into
+// one more pass.
+func historyChanged(school int) {
+	historyQueue.Lock()
+	defer historyQueue.Unlock()
+	if _, running := historyQueue.again[school]; running {
+		historyQueue.again[school] = true
+		return
+	}
+	historyQueue.again[school] = false
+	go func() {
+		for {
+			db := dbConn()
+			if err := recordBoards(db, school, 0); err != nil {
+				log.Println("RANK HISTORY: " + err.Error())
+			}
+			db.Close()
+
+			historyQueue.Lock()
+			if !historyQueue.again[school] {
+				delete(historyQueue.again, school)
+				historyQueue.Unlock()
+				return
+			}
+			historyQueue.again[school] = false
+			historyQueue.Unlock()
+		}
+	}()
+}
+
+// snapshotRanks records every board the school has, as of a snapshot.
+func snapshotRanks(db *sql.DB, school int, snapshotID int) error {
+	return recordBoards(db, school, snapshotID)
+}
+
+// downsample merges chronological points into at most n points, each
+// covering an equal slice of the time from first to last.
+func downsample(points []*models.RankPoint, first, last time.Time, n int) []*models.RankPoint {
+	if len(points) <= n {
+		return points
+	}
+	width := last.Sub(first) / time.Duration(n)
+	merged := make([]*models.RankPoint, 0, n)
+	bucket := -1
+	for _, point := range points {
+		b := 0
+		if width > 0 {
+			b = int(point.At.Sub(first) / width)
+		}
+		if b >= n {
+			b = n - 1
+		}
+		if b != bucket {
+			bucket = b
+			copied := *point
+			merged = append(merged, &copied)
+			continue
+		}
+		current := merged[len(merged)-1]
+		current.At, current.Rank, current.Score = point.At, point.Rank, point.Score
+		current.BestRank = minInt(current.BestRank, point.BestRank)
+		if point.WorstRank > current.WorstRank {
+			current.WorstRank = point.WorstRank
+		}
+		current.Samples += point.Samples
+	}
+	return merged
+}
+
+/******************************************************************************/
+
+// StudentRankHistory serves a student's rank over time on a `board` (gpa by
+// default). `from` and `to` are inclusive dates; `points` caps the number
+// of points returned, merging neighbouring ones over long ranges.
+func StudentRankHistory(w http.ResponseWriter, r *http.Request) {
+	points := defaultHistoryPoints
+	if v := r.URL.Query().Get("points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			invalidField(w, "points", "must be a positive integer")
+			return
+		}
+		points = minInt(n, maxHistoryPoints)
+	}
+	var from, to time.Time
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(name); v != "" {
+			parsed, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				invalidField(w, name, "must be a date like 2006-01-02")
+				return
+			}
+			*t = parsed
+		}
+	}
+	if !to.IsZero() {
+		to = to.AddDate(0, 0, 1)
+	}
+	studentID, err := strconv.Atoi(mux.Vars(r)["studentId"])
+	if err != nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	tenant := tenantOf(r)
+	slug := r.URL.Query().Get("board")
+	if slug == "" {
+		slug = "gpa"
+	}
+	board, err := resolveBoard(db, tenant.SchoolID, slug)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if board == nil || !canView(tenant, board) {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	if ok, err := studentInSchool(db, tenant.SchoolID, studentID); err != nil || !ok {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+
+	where := "school_id = ? AND board = ? AND student_id = ?"
+	args := []interface{}{tenant.SchoolID, board.Slug, studentID}
+	if !from.IsZero() {
+		where += " AND recorded_at >= ?"
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		where += " AND recorded_at < ?"
+		args = append(args, to)
+	}
+	rows, err := db.Query("SELECT rank_position, score, recorded_at FROM leaderboard.rank_history WHERE "+where+
+		" ORDER BY recorded_at, id", args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	series := make([]*models.RankPoint, 0)
+	for rows.Next() {
+		point := &models.RankPoint{Samples: 1}
+		var recordedAt mysql.NullTime
+		if err := rows.Scan(&point.Rank, &point.Score, &recordedAt); err != nil {
+			serverError(w, err)
+			return
+		}
+		point.At, point.BestRank, point.WorstRank = recordedAt.Time, point.Rank, point.Rank
+		series = append(series, point)
+	}
+	if len(series) > 0 {
+		if from.IsZero() {
+			from = series[0].At
+		}
+		if to.IsZero() {
+			to = series[len(series)-1].At
+		}
+		series = downsample(series, from, to, points)
+	}
+	writeJSON(w, http.StatusOK, &models.RankHistory{Board: board.Slug, StudentID: studentID, Points: series})
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// What a rate limit counts requests by.
+const (
+	// The client's address.
+	ByIP = iota
+	// The signed-in user, or the address when no one is signed in.
+	ByUser
+	// The X-API-Key header, or the user or address when there is none.
+	// Keys are not checked, so a limit by key should be paired with a
+	// looser one by address that a client inventing keys still runs into.
+	ByAPIKey
+)
+
+// Requests refused, by limit, published at /api/metrics.
+var rateLimited = expvar.NewMap("rate_limited")
+
+// A RateLimit allows each client Requests requests Per period. Clients
+// have a bucket of that many tokens, refilled evenly over the period, so
+// they may spend it in a burst or spread it out.
+type RateLimit struct {
+	Name     string
+	Requests int
+	Per      time.Duration
+	// ByIP, ByUser or ByAPIKey.
+	By int
+	// The methods the limit applies to; all of them when empty.
+	Methods []string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// The state of one client's bucket after a request.
+type allowance struct {
+	limit     *RateLimit
+	ok        bool
+	remaining int
+	// Until the bucket is full again, and, when the request was refused,
+	// until it has a token.
+	reset, retryAfter time.Duration
+}
+
+func (l *RateLimit) applies(method string) bool {
+	if len(l.Methods) == 0 {
+		return true
+	}
+	return containsString(l.Methods, method)
+}
+
+// clientIP is the address the request came from. Forwarding headers are
+// not trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// keyOf names the bucket a request is counted in.
+func (l *RateLimit) keyOf(r *http.Request) string {
+	if l.By == ByAPIKey {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			return "key " + hex.EncodeToString(sum[:16])
+		}
+	}
+	if l.By != ByIP {
+		if username := tenantOf(r).Username; username != "" {
+			return "user " + username
+		}
+	}
+	return "ip " + clientIP(r)
+}
+
+// refill returns the client's bucket, topped up to now. l.mu must be held.
+func (l *RateLimit) refill(key string, now time.Time) *bucket {
+	rate := float64(l.Requests) / l.Per.Seconds()
+	capacity := float64(l.Requests)
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	// Buckets that have filled up are the same as no bucket.
+	if now.Sub(l.swept) > l.Per {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.at).Seconds()*rate >= capacity {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: capacity, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+	return b
+}
+
+// take spends one token from the client's bucket in each limit, but only
+// if every one of them has a token: a request refused by one limit uses up
+// nothing of the others. keys[i] is the client's bucket in limits[i].
+func take(limits []*RateLimit, keys []string, now time.Time) []*allowance {
+	// Limits are always locked in the order given, and each is only ever
+	// listed once for a route, so two requests cannot deadlock.
+	buckets := make([]*bucket, len(limits))
+	ok := true
+	for i, l := range limits {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		buckets[i] = l.refill(keys[i], now)
+		ok = ok && buckets[i].tokens >= 1
+	}
+
+	allowances := make([]*allowance, len(limits))
+	for i, l := range limits {
+		b := buckets[i]
+		rate := float64(l.Requests) / l.Per.Seconds()
+		a := &allowance{limit: l, ok: b.tokens >= 1}
+		if ok {
+			b.tokens--
+		} else if !a.ok {
+			a.retryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		}
+		a.remaining = int(b.tokens)
+		a.reset = time.Duration((float64(l.Requests) - b.tokens) / rate * float64(time.Second))
+		allowances[i] = a
+	}
+	return allowances
+}
+
+// seconds rounds up to whole seconds, as the headers give them.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// Limit applies rate limits to a group of routes. A request must be
+// allowed by every limit that applies to it; refused requests are answered
+// 429 with Retry-After. Every response carries the RateLimit-* headers of
+// the limit closest to running out.
+func Limit(limits ...*RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var applied []*RateLimit
+			var keys []string
+			for _, l := range limits {
+				if l.applies(r.Method) {
+					applied = append(applied, l)
+					keys = append(keys, l.keyOf(r))
+				}
+			}
+			var tightest, refused *allowance
+			for _, a := range take(applied, keys, time.Now()) {
+				if tightest == nil || a.remaining < tightest.remaining {
+					tightest = a
+				}
+				if !a.ok && (refused == nil || a.retryAfter > refused.retryAfter) {
+					refused = a
+				}
+			}
+			if refused != nil {
+				tightest = refused
+			}
+			if tightest != nil {
+				l := tightest.limit
+				w.Header().Set("RateLimit-Policy", strconv.Itoa(l.Requests)+";w="+seconds(l.Per))
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(l.Requests))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.remaining))
+				w.Header().Set("RateLimit-Reset", seconds(tightest.reset))
+			}
+			if refused != nil {
+				rateLimited.Add(refused.limit.Name, 1)
+				w.Header().Set("Retry-After", seconds(refused.retryAfter))
+				problem(w, http.StatusTooManyRequests, "too many requests; retry in "+seconds(refused.retryAfter)+" seconds")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
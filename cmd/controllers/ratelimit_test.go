@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// Seconds after start of each request.
+		at   []float64
+		want []bool
+		// The allowance of the last request.
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{"burst up to the limit", []float64{0, 0, 0}, []bool{true, true, true}, 0, 0},
+		{"refused past the limit", []float64{0, 0, 0, 0}, []bool{true, true, true, false}, 0, 20 * time.Second},
+		{"refills evenly", []float64{0, 0, 0, 20}, []bool{true, true, true, true}, 0, 0},
+		{"partly refilled", []float64{0, 0, 0, 10}, []bool{true, true, true, false}, 0, 10 * time.Second},
+		{"never more than the limit", []float64{0, 600, 600, 600, 600}, []bool{true, true, true, true, false}, 0, 20 * time.Second},
+		{"refused requests cost nothing", []float64{0, 0, 0, 5, 10, 20}, []bool{true, true, true, false, false, true}, 0, 0},
+		{"spread out", []float64{0, 30, 60, 90}, []bool{true, true, true, true}, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &RateLimit{Name: "test", Requests: 3, Per: time.Minute}
+			var a *allowance
+			for i, at := range tt.at {
+				now := start.Add(time.Duration(at * float64(time.Second)))
+				a = take([]*RateLimit{l}, []string{"ip 192.0.2.1"}, now)[0]
+				if a.ok != tt.want[i] {
+					t.Errorf("request %d at %vs: ok = %v, want %v", i, at, a.ok, tt.want[i])
+				}
+			}
+			if a.remaining != tt.remaining || a.retryAfter != tt.retryAfter {
+				t.Errorf("last allowance: remaining %d, retry after %v; want %d, %v",
+					a.remaining, a.retryAfter, tt.remaining, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestTakeKeepsClientsApart(t *testing.T) {
+	l := &RateLimit{Name: "test", Requests: 1, Per: time.Minute}
+	now := time.Now()
+	for _, key := range []string{"ip 192.0.2.1", "ip 192.0.2.2", "user alice"} {
+		if a := take([]*RateLimit{l}, []string{key}, now)[0]; !a.ok {
+			t.Errorf("first request of %s refused", key)
+		}
+	}
+	if a := take([]*RateLimit{l}, []string{"ip 192.0.2.1"}, now)[0]; a.ok {
+		t.Error("second request of ip 192.0.2.1 allowed")
+	}
+}
+
+// A request refused by one limit must not use up the others.
+func TestTakeSpendsOnlyWhenAllAllow(t *testing.T) {
+	tight := &RateLimit{Name: "tight", Requests: 1, Per: time.Minute}
+	loose := &RateLimit{Name: "loose", Requests: 5, Per: time.Minute}
+	limits, keys := []*RateLimit{tight, loose}, []string{"ip 192.0.2.1", "ip 192.0.2.1"}
+	now := time.Now()
+	for i, want := range []bool{true, false, false, false} {
+		allowances := take(limits, keys, now)
+		if allowances[0].ok != want {
+			t.Errorf("request %d: tight ok = %v, want %v", i, allowances[0].ok, want)
+		}
+		if !allowances[1].ok {
+			t.Errorf("request %d: loose refused", i)
+		}
+		if allowances[1].remaining != 4 {
+			t.Errorf("request %d: loose remaining = %d, want 4", i, allowances[1].remaining)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	reads := &RateLimit{Name: "read", Requests: 2, Per: time.Minute, By: ByIP, Methods: []string{http.MethodGet}}
+	writes := &RateLimit{Name: "write", Requests: 1, Per: time.Minute, By: ByIP, Methods: []string{http.MethodPost}}
+	handler := Limit(reads, writes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		method     string
+		remoteAddr string
+		status     int
+		limit      string
+		remaining  string
+	}{
+		{http.MethodGet, "192.0.2.1:1000", http.StatusNoContent, "2", "1"},
+		{http.MethodPost, "192.0.2.1:1001", http.StatusNoContent, "1", "0"},
+		{http.MethodGet, "192.0.2.1:1002", http.StatusNoContent, "2", "0"},
+		{http.MethodGet, "192.0.2.1:1003", http.StatusTooManyRequests, "2", "0"},
+		{http.MethodPost, "192.0.2.1:1004", http.StatusTooManyRequests, "1", "0"},
+		{http.MethodGet, "192.0.2.2:1000", http.StatusNoContent, "2", "1"},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/students", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("request %d: status %d, want %d", i, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("RateLimit-Limit"); got != tt.limit {
+			t.Errorf("request %d: RateLimit-Limit %q, want %q", i, got, tt.limit)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("request %d: RateLimit-Remaining %q, want %q", i, got, tt.remaining)
+		}
+		if refused := rec.Header().Get("Retry-After") != ""; refused != (tt.status == http.StatusTooManyRequests) {
+			t.Errorf("request %d: Retry-After %q", i, rec.Header().Get("Retry-After"))
+		}
+	}
+}
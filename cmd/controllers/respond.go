@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Problem types beyond the plain HTTP statuses, which are "about:blank".
+const (
+	// One or more parameters or body fields are invalid; the problem lists
+	// them under errors.
+	problemValidation = "/problems/validation"
+	// The request is malformed in a way no single field is to blame for.
+	problemMalformed = "/problems/malformed-request"
+)
+
+// writeJSON encodes v as the JSON body of the response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
+
+// writeProblem writes p as an application/problem+json response, filling in
+// the type and title of a plain HTTP status.
+func writeProblem(w http.ResponseWriter, p *models.Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	bytes, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(bytes)
+}
+
+// problem responds with the plain problem for an HTTP status. detail may be
+// empty.
+func problem(w http.ResponseWriter, status int, detail string) {
+	writeProblem(w, &models.Problem{Status: status, Detail: detail})
+}
+
+// WriteProblem is problem for the routes served outside this package.
+func WriteProblem(w http.ResponseWriter, status int, detail string) {
+	problem(w, status, detail)
+}
+
+// serverError logs err and responds 500 without revealing it.
+func serverError(w http.ResponseWriter, err error) {
+	log.Println(err.Error())
+	problem(w, http.StatusInternalServerError, "")
+}
+
+// invalid responds 400 to a request that cannot be served as given. A
+// *QueryError, or a JSON body with a field of the wrong type, is reported as
+// a validation problem naming the field.
+func invalid(w http.ResponseWriter, err error) {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		err = &QueryError{typeErr.Field, -1, "must be " + jsonKind(typeErr.Type.Kind()) + ", not " + typeErr.Value}
+	}
+	if queryErr, ok := err.(*QueryError); ok {
+		field := &models.FieldError{Field: queryErr.Param, Detail: queryErr.Msg}
+		if queryErr.Pos >= 0 {
+			field.Position = queryErr.Pos + 1
+		}
+		invalidFields(w, field)
+		return
+	}
+	writeProblem(w, &models.Problem{
+		Type:   problemMalformed,
+		Title:  "Your request is malformed",
+		Status: http.StatusBadRequest,
+		Detail: err.Error(),
+	})
+}
+
+// requestError responds 400 to an error naming a bad parameter or field and
+// 500 to anything else.
+func requestError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*QueryError); ok {
+		invalid(w, err)
+		return
+	}
+	serverError(w, err)
+}
+
+// jsonKind names the JSON type a Go kind is decoded from.
+func jsonKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a number"
+}
+
+// invalidField responds 400 naming the body field or parameter at fault.
+func invalidField(w http.ResponseWriter, field, msg string) {
+	invalidFields(w, &models.FieldError{Field: field, Detail: msg})
+}
+
+// invalidFields responds 400 listing every field at fault.
+func invalidFields(w http.ResponseWriter, fields ...*models.FieldError) {
+	details := make([]string, 0, len(fields))
+	for _, field := range fields {
+		details = append(details, field.Field+": "+field.Detail)
+	}
+	writeProblem(w, &models.Problem{
+		Type:   problemValidation,
+		Title:  "Your request has invalid fields",
+		Status: http.StatusBadRequest,
+		Detail: strings.Join(details, "; "),
+		Errors: fields,
+	})
+}
+
+// required returns a field error for each named field whose value is empty.
+// Names and values alternate.
+func required(fields ...string) []*models.FieldError {
+	missing := make([]*models.FieldError, 0)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			missing = append(missing, &models.FieldError{Field: fields[i], Detail: "is required"})
+		}
+	}
+	return missing
+}
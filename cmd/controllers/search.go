@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"database/sql"
+	"leaderboard-bk/cmd/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Search results score at least this much unless `min_score` says otherwise.
+const defaultMinScore = 0.6
+
+// Autocomplete returns this many suggestions unless `limit` says otherwise.
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 50
+)
+
+// foldings spell accented letters without their accents so that "José" is
+// found by "jose". Combining marks are dropped separately.
+var foldings = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ť': "t", 'ţ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'þ': "th",
+}
+
+// foldName lower-cases a name, strips its accents and splits it into words.
+// Apostrophes are dropped ("O'Brien" is "obrien"); any other punctuation
+// separates words.
+func foldName(s string) []string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case foldings[r] != "":
+			b.WriteString(foldings[r])
+		case unicode.Is(unicode.Mn, r), r == '\'', r == '’':
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Fields(b.String())
+}
+
+// trigrams returns the set of three letter sequences of a word, padded so
+// that its start and end count too.
+func trigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	grams := make(map[string]bool, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		grams[string(runes[i:i+3])] = true
+	}
+	return grams
+}
+
+// editDistance is the Levenshtein distance between two words.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// wordSimilarity scores two folded words between 0 and 1. Trigram overlap
+// favours long words sharing most of their letters; edit distance catches
+// single typos in short names, which share few trigrams.
+func wordSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ga, gb := trigrams(a), trigrams(b)
+	shared := 0
+	for gram := range ga {
+		if gb[gram] {
+			shared++
+		}
+	}
+	jaccard := float64(shared) / float64(len(ga)+len(gb)-shared)
+
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	edit := 1 - float64(editDistance(ra, rb))/float64(longest)
+	if edit > jaccard {
+		return edit
+	}
+	return jaccard
+}
+
+/******************************************************************************/
+
+// nameIndex is a school's in-process search index over student names.
+type nameIndex struct {
+	students []*models.Student
+	words    [][]string
+	// Students whose name contains each trigram.
+	grams map[string][]int
+	// Every name word, sorted, for prefix lookups.
+	prefixes []indexedWord
+}
+
+type indexedWord struct {
+	word    string
+	student int
+}
+
+func buildNameIndex(stus []*models.Student) *nameIndex {
+	index := &nameIndex{
+		students: stus,
+		words:    make([][]string, len(stus)),
+		grams:    make(map[string][]int),
+	}
+	for i, stu := range stus {
+		index.words[i] = foldName(stu.FirstName + " " + stu.LastName)
+		seen := make(map[string]bool)
+		for _, word := range index.words[i] {
+			index.prefixes = append(index.prefixes, indexedWord{word, i})
+			for gram := range trigrams(word) {
+				if !seen[gram] {
+					seen[gram] = true
+					index.grams[gram] = append(index.grams[gram], i)
+				}
+			}
+		}
+	}
+	sort.Slice(index.prefixes, func(i, j int) bool { return index.prefixes[i].word < index.prefixes[j].word })
+	return index
+}
+
+// search scores every student sharing a trigram with the query. A student's
+// score is the mean, over the query's words, of the best match among the
+// words of their name.
+func (index *nameIndex) search(query string, minScore float64) []*models.SearchResult {
+	terms := foldName(query)
+	candidates := make(map[int]bool)
+	for _, term := range terms {
+		for gram := range trigrams(term) {
+			for _, i := range index.grams[gram] {
+				candidates[i] = true
+			}
+		}
+	}
+
+	results := make([]*models.SearchResult, 0)
+	for i := range candidates {
+		total := 0.0
+		for _, term := range terms {
+			best := 0.0
+			for _, word := range index.words[i] {
+				if sim := wordSimilarity(term, word); sim > best {
+					best = sim
+				}
+			}
+			total += best
+		}
+		if score := roundTo(total/float64(len(terms)), 3); score >= minScore {
+			results = append(results, &models.SearchResult{Student: index.students[i], Score: score})
+		}
+	}
+	sortResults(results)
+	return results
+}
+
+// complete suggests students whose name words start with the query's words;
+// the last word may be partly typed. Names mostly covered by the query come
+// first.
+func (index *nameIndex) complete(query string, limit int) []*models.SearchResult {
+	terms := foldName(query)
+	if len(terms) == 0 {
+		return make([]*models.SearchResult, 0)
+	}
+	last := terms[len(terms)-1]
+	typed := 0
+	for _, term := range terms {
+		typed += len(term)
+	}
+
+	results := make([]*models.SearchResult, 0)
+	seen := make(map[int]bool)
+	for k := sort.Search(len(index.prefixes), func(k int) bool { return index.prefixes[k].word >= last }); k < len(index.prefixes); k++ {
+		entry := index.prefixes[k]
+		if !strings.HasPrefix(entry.word, last) {
+			break
+		}
+		if seen[entry.student] || !coversTerms(index.words[entry.student], terms[:len(terms)-1]) {
+			continue
+		}
+		seen[entry.student] = true
+		length := 0
+		for _, word := range index.words[entry.student] {
+			length += len(word)
+		}
+		score := float64(typed) / float64(length)
+		if score > 1 {
+			score = 1
+		}
+		results = append(results, &models.SearchResult{Student: index.students[entry.student], Score: roundTo(score, 3)})
+	}
+	sortResults(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// coversTerms reports whether every term starts one of the words.
+func coversTerms(words, terms []string) bool {
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sortResults orders results best first, then by last name and ID.
+func sortResults(results []*models.SearchResult) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if la, lb := strings.ToLower(a.Student.LastName), strings.ToLower(b.Student.LastName); la != lb {
+			return la < lb
+		}
+		return a.Student.ID < b.Student.ID
+	})
+}
+
+// searchIndexes holds each school's name index. An index is built on first
+// use and dropped whenever one of the school's students changes; the
+// generation keeps an index built from data read before a change from
+// being stored after it.
+var searchIndexes = struct {
+	sync.Mutex
+	bySchool   map[int]*nameIndex
+	generation map[int]int
+}{bySchool: make(map[int]*nameIndex), generation: make(map[int]int)}
+
+func schoolNameIndex(db *sql.DB, school int) (*nameIndex, error) {
+	searchIndexes.Lock()
+	index, generation := searchIndexes.bySchool[school], searchIndexes.generation[school]
+	searchIndexes.Unlock()
+	if index != nil {
+		return index, nil
+	}
+
+	stus, err := loadStudents(db, "school_id = ?", school)
+	if err != nil {
+		return nil, err
+	}
+	index = buildNameIndex(stus)
+	searchIndexes.Lock()
+	if searchIndexes.generation[school] == generation {
+		searchIndexes.bySchool[school] = index
+	}
+	searchIndexes.Unlock()
+	return index, nil
+}
+
+func invalidateNameIndex(school int) {
+	searchIndexes.Lock()
+	delete(searchIndexes.bySchool, school)
+	searchIndexes.generation[school]++
+	searchIndexes.Unlock()
+}
+
+/******************************************************************************/
+
+// SearchStudents finds students by name, tolerating typos and accents. `q`
+// is the name to look for; `min_score` (0 to 1) drops weaker matches.
+func SearchStudents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if len(foldName(query)) == 0 {
+		invalidField(w, "q", "is required")
+		return
+	}
+	minScore := defaultMinScore
+	if v := r.URL.Query().Get("min_score"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			invalidField(w, "min_score", "must be between 0 and 1")
+			return
+		}
+		minScore = f
+	}
+	db := dbConn()
+	defer db.Close()
+
+	index, err := schoolNameIndex(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	results := index.search(query, minScore)
+	writePage(w, r, results, func(i int) []interface{} {
+		return []interface{}{results[i].Score, strings.ToLower(results[i].Student.LastName), results[i].Student.ID}
+	}, []bool{true, false, false})
+}
+
+// AutocompleteStudents suggests students as a name is typed into `q`.
+func AutocompleteStudents(w http.ResponseWriter, r *http.Request) {
+	limit := defaultSuggestions
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			invalidField(w, "limit", "must be a positive integer")
+			return
+		}
+		limit = minInt(n, maxSuggestions)
+	}
+	db := dbConn()
+	defer db.Close()
+
+	index, err := schoolNameIndex(db, tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, index.complete(r.URL.Query().Get("q"), limit))
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"leaderboard-bk/cmd/models"
+	"reflect"
+	"testing"
+)
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"José García", []string{"jose", "garcia"}},
+		{"  Ana   Lee ", []string{"ana", "lee"}},
+		{"O'Brien", []string{"obrien"}},
+		{"D’Angelo", []string{"dangelo"}},
+		{"Mary-Kate St. John", []string{"mary", "kate", "st", "john"}},
+		{"Zoë Þórsdóttir", []string{"zoe", "thorsdottir"}},
+		{"Straße", []string{"strasse"}},
+		// A combining acute accent rather than a precomposed letter.
+		{"Jose\u0301", []string{"jose"}},
+		{"Ｌｉｎ 3", []string{"ｌｉｎ", "3"}},
+		{"!?", []string{}},
+	}
+	for _, tt := range tests {
+		if got := foldName(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("foldName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"ana", "", 3},
+		{"ana", "ana", 0},
+		{"jon", "john", 1},
+		{"smith", "smyth", 1},
+		{"kitten", "sitting", 3},
+		{"lee", "eel", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestWordSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"garcia", "garcia", 1},
+		// Edit distance wins for a typo in a short name.
+		{"jon", "john", 0.75},
+		{"smith", "smyth", 0.8},
+		{"ana", "bob", 0},
+	}
+	for _, tt := range tests {
+		if got := roundTo(wordSimilarity(tt.a, tt.b), 3); got != tt.want {
+			t.Errorf("wordSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if wordSimilarity(tt.a, tt.b) != wordSimilarity(tt.b, tt.a) {
+			t.Errorf("wordSimilarity(%q, %q) is not symmetric", tt.a, tt.b)
+		}
+	}
+	// Longer words sharing most of their letters score high on trigrams.
+	if got := wordSimilarity("alexandra", "alexandria"); got < 0.8 {
+		t.Errorf("wordSimilarity(alexandra, alexandria) = %v, want at least 0.8", got)
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	stus := []*models.Student{
+		{ID: 1, FirstName: "José", LastName: "García"},
+		{ID: 2, FirstName: "Jon", LastName: "Smith"},
+		{ID: 3, FirstName: "John", LastName: "Smyth"},
+		{ID: 4, FirstName: "Anna", LastName: "O'Brien"},
+	}
+	index := buildNameIndex(stus)
+	ids := func(results []*models.SearchResult) []int {
+		found := make([]int, 0, len(results))
+		for _, result := range results {
+			found = append(found, result.Student.ID)
+		}
+		return found
+	}
+
+	searches := []struct {
+		query string
+		want  []int
+	}{
+		{"jose garcia", []int{1}},
+		{"JOSÉ", []int{1}},
+		// Jon Smith scores (0.75 + 1) / 2, John Smyth (1 + 0.8) / 2.
+		{"john smith", []int{3, 2}},
+		{"obrien", []int{4}},
+		{"o'brien", []int{4}},
+		{"zzz", []int{}},
+	}
+	for _, tt := range searches {
+		if got := ids(index.search(tt.query, defaultMinScore)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	completions := []struct {
+		query string
+		want  []int
+	}{
+		{"jo", []int{2, 3, 1}},
+		{"john sm", []int{3}},
+		{"sm", []int{2, 3}},
+		{"smy", []int{3}},
+		{"", []int{}},
+	}
+	for _, tt := range completions {
+		if got := ids(index.complete(tt.query, defaultSuggestions)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// The decimal places of a stat defined without a precision, as in the
+// column's default.
+const defaultStatPrecision = 2
+
+// loadStatDefinitions returns the stat definitions matching the where clause.
+func loadStatDefinitions(db *sql.DB, where string, args ...interface{}) ([]*models.StatDefinition, error) {
+	rows, err := db.Query("SELECT id, sport, name, unit, stat_precision, lower_is_better FROM leaderboard.stat_definitions WHERE "+
+		where+" ORDER BY sport, name, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]*models.StatDefinition, 0)
+	for rows.Next() {
+		stat := new(models.StatDefinition)
+		if err := rows.Scan(&stat.ID, &stat.Sport, &stat.Name, &stat.Unit, &stat.Precision, &stat.LowerIsBetter); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// loadStatEntries returns the stat entries matching the where clause, most
+// recent first.
+func loadStatEntries(db *sql.DB, where string, args ...interface{}) ([]*models.StatEntry, error) {
+	rows, err := db.Query("SELECT id, stat_id, student_id, value, recorded_at FROM leaderboard.stat_entries WHERE "+
+		where+" ORDER BY recorded_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*models.StatEntry, 0)
+	for rows.Next() {
+		entry := new(models.StatEntry)
+		var recordedAt mysql.NullTime
+		if err := rows.Scan(&entry.ID, &entry.StatID, &entry.StudentID, &entry.Value, &recordedAt); err != nil {
+			return nil, err
+		}
+		entry.RecordedAt = recordedAt.Time
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// bestStats returns each student's best entry for a stat, honouring the
+// stat's sort direction.
+func bestStats(stat *models.StatDefinition, entries []*models.StatEntry) map[int]*models.StatEntry {
+	best := make(map[int]*models.StatEntry)
+	for _, entry := range entries {
+		current, ok := best[entry.StudentID]
+		if !ok || (stat.LowerIsBetter && entry.Value < current.Value) || (!stat.LowerIsBetter && entry.Value > current.Value) {
+			best[entry.StudentID] = entry
+		}
+	}
+	return best
+}
+
+// roundTo rounds v to the given number of decimal places.
+func roundTo(v float64, precision int) float64 {
+	scale := math.Pow(10, float64(precision))
+	return math.Round(v*scale) / scale
+}
+
+// loadStat returns one of the school's stat definitions, or nil.
+func loadStat(db *sql.DB, school int, id interface{}) (*models.StatDefinition, error) {
+	stats, err := loadStatDefinitions(db, "id = ? AND school_id = ?", id, school)
+	if err != nil || len(stats) == 0 {
+		return nil, err
+	}
+	return stats[0], nil
+}
+
+// statLeaderboard ranks the given students by their best entry for the stat.
+func statLeaderboard(db *sql.DB, stat *models.StatDefinition, stus []*models.Student, chain []string) ([]*models.LeaderboardEntry, error) {
+	entries, err := loadStatEntries(db, "stat_id = ?", stat.ID)
+	if err != nil {
+		return nil, err
+	}
+	best := bestStats(stat, entries)
+
+	board := make([]*models.LeaderboardEntry, 0, len(best))
+	for _, stu := range stus {
+		if entry, ok := best[stu.ID]; ok {
+			board = append(board, &models.LeaderboardEntry{Student: stu, Score: roundTo(entry.Value, stat.Precision)})
+		}
+	}
+	return rankEntries(board, stat.LowerIsBetter, chain), nil
+}
+
+/******************************************************************************/
+
+func IndexStatDefinitions(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	where := "school_id = ?"
+	args := []interface{}{tenantOf(r).SchoolID}
+	if sport := r.URL.Query().Get("sport"); sport != "" {
+		where += " AND sport = ?"
+		args = append(args, sport)
+	}
+	stats, err := loadStatDefinitions(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, stats, func(i int) []interface{} {
+		return []interface{}{strings.ToLower(stats[i].Sport), strings.ToLower(stats[i].Name), stats[i].ID}
+	}, []bool{false, false, false})
+}
+
+func InsertStatDefinition(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var body struct {
+		Sport string `json:"sport"`
+		Name  string `json:"name"`
+		Unit  string `json:"unit"`
+		// Left out, it is defaultStatPrecision rather than 0.
+		Precision     *int `json:"precision"`
+		LowerIsBetter bool `json:"lower_is_better"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalid(w, err)
+		return
+	}
+	if missing := required("sport", body.Sport, "name", body.Name); len(missing) > 0 {
+		invalidFields(w, missing...)
+		return
+	}
+	stat := models.StatDefinition{Sport: body.Sport, Name: body.Name, Unit: body.Unit,
+		Precision: defaultStatPrecision, LowerIsBetter: body.LowerIsBetter}
+	if body.Precision != nil {
+		stat.Precision = *body.Precision
+	}
+	if stat.Precision < 0 || stat.Precision > 6 {
+		invalidField(w, "precision", "must be between 0 and 6")
+		return
+	}
+
+	res, err := db.Exec("INSERT INTO leaderboard.stat_definitions(school_id, sport, name, unit, stat_precision, lower_is_better) "+
+		"VALUES(?, ?, ?, ?, ?, ?)", tenantOf(r).SchoolID, stat.Sport, stat.Name, stat.Unit, stat.Precision, stat.LowerIsBetter)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+			problem(w, http.StatusConflict, "stat "+strconv.Quote(stat.Name)+" already exists for "+stat.Sport)
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	stat.ID = int(id)
+	log.Println("INSERT STAT: Sport: " + stat.Sport + " | Name: " + stat.Name)
+	writeJSON(w, http.StatusCreated, stat)
+}
+
+func DeleteStatDefinition(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM leaderboard.stat_definitions WHERE id = ? AND school_id = ?",
+		mux.Vars(r)["statId"], tenantOf(r).SchoolID); err != nil {
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenantOf(r).SchoolID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************/
+
+// IndexStatEntries lists the entries recorded for a stat, optionally for a
+// single `student`.
+func IndexStatEntries(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	where := "stat_id = ? AND school_id = ?"
+	args := []interface{}{mux.Vars(r)["statId"], tenantOf(r).SchoolID}
+	if student := r.URL.Query().Get("student"); student != "" {
+		where += " AND student_id = ?"
+		args = append(args, student)
+	}
+	entries, err := loadStatEntries(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, entries, func(i int) []interface{} {
+		return []interface{}{micros(entries[i].RecordedAt), entries[i].ID}
+	}, []bool{true, true})
+}
+
+// InsertStatEntry records a performance. `recorded_at` defaults to now.
+func InsertStatEntry(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	school := tenantOf(r).SchoolID
+	stat, err := loadStat(db, school, mux.Vars(r)["statId"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if stat == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+
+	var entry models.StatEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		invalid(w, err)
+		return
+	}
+	if ok, err := studentInSchool(db, school, entry.StudentID); err != nil || !ok {
+		invalidField(w, "student_id", "unknown student")
+		return
+	}
+	if entry.RecordedAt.IsZero() {
+		entry.RecordedAt = time.Now()
+	}
+	entry.StatID = stat.ID
+
+	res, err := db.Exec("INSERT INTO leaderboard.stat_entries(school_id, stat_id, student_id, value, recorded_at) VALUES(?, ?, ?, ?, ?)",
+		school, stat.ID, entry.StudentID, entry.Value, entry.RecordedAt)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	entry.ID = int(id)
+	if err := checkStatRecord(db, school, stat, &entry); err != nil {
+		log.Println("RECORDS: " + err.Error())
+	}
+	touchStudent(db, school, entry.StudentID)
+	boardsChanged(school)
+	log.Println("INSERT STAT ENTRY: " + stat.Name + " | Student: " + strconv.Itoa(entry.StudentID))
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+/******************************************************************************/
+
+// StatLeaderboard ranks students by their best entry for a stat.
+func StatLeaderboard(w http.ResponseWriter, r *http.Request) {
+	chain, err := tieBreakerChain(r, "sport_stats")
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	school := tenantOf(r).SchoolID
+	stat, err := loadStat(db, school, mux.Vars(r)["statId"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if stat == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	stus, err := loadStudents(db, currentStudents, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	board, err := statLeaderboard(db, stat, stus, chain)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeEntryPage(w, r, board, stat.LowerIsBetter, chain)
+}
@@ -4,13 +4,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
 	"leaderboard-bk/cmd/models"
 	"log"
 	"net/http"
+	"strconv"
 	_ "sync"
-	_ "time"
+	"time"
 )
 
 
@@ -28,149 +29,277 @@ func dbConn() (db *sql.DB) {
 
 func IndexStudents(w http.ResponseWriter, r *http.Request) {
 	db := dbConn()
+	defer db.Close()
 	if r.Method != "GET" {
-		http.Error(w, http.StatusText(405), 405)
+		problem(w, http.StatusMethodNotAllowed, "")
 		return
 	}
 
-	rows, err := db.Query("SELECT * FROM leaderboard.students")
+	filter, err := compileFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	keys, err := compileSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	fields, err := selectFields(r.URL.Query().Get("fields"))
 	if err != nil {
-		http.Error(w, http.StatusText(500), 500)
+		invalid(w, err)
 		return
 	}
-	defer rows.Close()
 
-	stus := make([]*models.Student, 0)
-	var newTime *mysql.NullTime
-	for rows.Next() {
-		stu := new(models.Student)
-		err := rows.Scan(&stu.ID,
-			&stu.FirstName,
-			&stu.LastName,
-			&stu.GPA,
-			&stu.Sport,
-			&newTime)
-		if err != nil {
-			http.Error(w, http.StatusText(500), 500)
-		}
-		stus = append(stus, stu)
+	limit, page, err := pageParams(r)
+	if err != nil {
+		invalid(w, err)
+		return
 	}
-	if err = rows.Err(); err != nil {
-		http.Error(w, http.StatusText(500), 500)
+	sortSpec := r.URL.Query().Get("sort")
+	if page != nil && (page.Sort != sortSpec || len(page.Keys) != len(keys)) {
+		invalidField(w, "cursor", "does not belong to this sort")
 		return
 	}
 
+	// Graduates are only listed when asked for, and then on their own.
+	where := currentStudents
+	if r.URL.Query().Get("archived") == "true" {
+		where = "school_id = ? AND archived_at IS NOT NULL"
+	}
+	args := []interface{}{tenantOf(r).SchoolID}
+	if filter != nil {
+		where += " AND " + filter.sql(&args)
+	}
+	order := keys
+	if page != nil {
+		where += " AND " + keysetCondition(keys, page, &args)
+		if page.Dir == "prev" {
+			order = reverseSort(keys)
+		}
+	}
+	stus, err := loadStudents(db, where+" ORDER BY "+orderBy(order)+" LIMIT "+strconv.Itoa(limit+1), args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	more := len(stus) > limit
+	if more {
+		stus = stus[:limit]
+	}
+	if page != nil && page.Dir == "prev" {
+		for i, j := 0, len(stus)-1; i < j; i, j = i+1, j-1 {
+			stus[i], stus[j] = stus[j], stus[i]
+		}
+	}
+	var next, prev *cursor
+	if len(stus) > 0 {
+		if more || (page != nil && page.Dir == "prev") {
+			next = &cursor{Keys: studentKey(stus[len(stus)-1], keys), Dir: "next", Sort: sortSpec}
+		}
+		if page != nil && (more || page.Dir == "next") {
+			prev = &cursor{Keys: studentKey(stus[0], keys), Dir: "prev", Sort: sortSpec}
+		}
+	}
+	if apiVersion(r) >= apiV2 {
+		projected := make([]interface{}, 0, len(stus))
+		for _, stu := range stus {
+			projected = append(projected, project(stu, fields))
+		}
+		setPageLinks(w, r, next, prev)
+		writeJSON(w, http.StatusOK, projected)
+		return
+	}
+	// Version 1 concatenates the students' JSON objects, mislabelled as a
+	// form. Marshal every student before writing anything, so that a
+	// failure can still be reported as a problem.
+	body := make([]byte, 0)
 	for _, stu := range stus {
 		//_, err := fmt.Fprint(w, "%d, %s, %s, %d, %s", stu.ID, stu.FirstName, stu.LastName, stu.GPA, stu.Sport)
 		//if err != nil {
 		//	http.Error(w, http.StatusText(500), 500)
 		//	return
 		//}
-		bytes, err := json.Marshal(stu)
+		bytes, err := json.Marshal(project(stu, fields))
 		if err != nil {
-			log.Panic(err.Error())
+			serverError(w, err)
+			return
 		}
-
-		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(bytes)
+		body = append(body, bytes...)
 	}
+	setPageLinks(w, r, next, prev)
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 /*******************************************************************************/
 
+// FetchStudent serves a student. Version 1 of the route lists every student
+// instead, as it always has.
+func FetchStudent(w http.ResponseWriter, r *http.Request) {
+	if apiVersion(r) < apiV2 {
+		IndexStudents(w, r)
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	stus, err := loadStudents(db, "id = ? AND school_id = ?", mux.Vars(r)["studentId"], tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(stus) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, stus[0])
+}
+
 func ModifyStudent(w http.ResponseWriter, r *http.Request) {
 	db := dbConn()
+	defer db.Close()
 	nId := r.URL.Query().Get("id")
-	selDB, err := db.Query("SELECT * FROM leaderboard.students", nId)
+	stus, err := loadStudents(db, "id = ? AND school_id = ?", nId, tenantOf(r).SchoolID)
 	if err != nil {
-		panic(err.Error())
+		serverError(w, err)
+		return
 	}
-	stu := models.Student{}
-	for selDB.Next() {
-		var ID int
-		var firstName, lastName, sport string
-		var gpa float32
-		err = selDB.Scan(&ID, &firstName, &lastName, &gpa, &sport)
-		if err != nil {
-			panic(err.Error())
-		}
-		stu.ID = ID
-		stu.FirstName = firstName
-		stu.LastName = lastName
-		stu.GPA = gpa
-		stu.Sport = sport
+	if len(stus) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
 	}
-	defer db.Close()
-	http.Redirect(w, r, "/", 301)
+	writeJSON(w, http.StatusOK, stus[0])
 }
 
 /******************************************************************************/
 
 func InsertStudent(w http.ResponseWriter, r *http.Request) {
 	db := dbConn()
-	if r.Method == "POST" {
-		var s *models.Student_test
-		err := json.NewDecoder(r.Body).Decode(&s)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		log.Println(s.FirstName)
-		firstName := s.FirstName
-		lastName := s.LastName
-		gpa := s.GPA
-		sport := s.Sport
-		insForm, err :=
-			db.Prepare("INSERT INTO leaderboard.students(firstName, lastName, gpa, sport) VALUES(?, ?, ?, ?)")
-		if err != nil {
-			panic(err.Error())
-		}
-		_, _ = insForm.Exec(firstName, lastName, gpa, sport)
-		fts := fmt.Sprintf("f%",  gpa)
-		log.Println(
-			"INSERT: First Name: " + firstName +
-			" | Last Name: " + lastName +
-			" | GPA: " + fts +
-			" | Sport " + sport)
-	}
 	defer db.Close()
-	http.Redirect(w, r, "/", 301)
+	if r.Method != "POST" {
+		problem(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var s *models.Student_test
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	log.Println(s.FirstName)
+	gradYear, gradeLevel, err := cohortOf(s.GradYear, s.GradeLevel, time.Now())
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	firstName := s.FirstName
+	lastName := s.LastName
+	gpa := s.GPA
+	credits := s.Credits
+	sport := s.Sport
+	insForm, err :=
+		db.Prepare("INSERT INTO leaderboard.students(school_id, firstName, lastName, gpa, credits, sport, grad_year, grade_level, archived_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	res, err := insForm.Exec(tenantOf(r).SchoolID, firstName, lastName, gpa, credits, sport, gradYear, gradeLevel,
+		graduatedAt(gradYear, gradeLevel, time.Now()))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	studentChanged(db, tenantOf(r).SchoolID, int(id))
+	fts := fmt.Sprintf("%d",  gpa)
+	log.Println(
+		"INSERT: First Name: " + firstName +
+		" | Last Name: " + lastName +
+		" | GPA: " + fts +
+		" | Sport " + sport)
+
+	stus, err := loadStudents(db, "id = ?", id)
+	if err != nil || len(stus) == 0 {
+		serverError(w, fmt.Errorf("reading back student %d: %v", id, err))
+		return
+	}
+	w.Header().Set("Location", apiPath(r, "/api/students/"+strconv.FormatInt(id, 10)))
+	writeJSON(w, http.StatusCreated, stus[0])
 }
 
 /******************************************************************************/
 
 func UpdateStudent(w http.ResponseWriter, r *http.Request) {
 	db := dbConn()
-	log.Println("Now I am here")
-	if r.Method == "POST" {
-		name := r.FormValue("name")
-		city := r.FormValue("city")
-		id := r.FormValue("uid")
-		insForm, err := db.Prepare("UPDATE leaderboard.students SET first_name=?, last_name=?, gpa=?, sport=?,  WHERE not last_name=?")
-		if err != nil {
-			panic(err.Error())
-		}
-		_, _ = insForm.Exec(name, city, id)
-		log.Println("UPDATE: Name: " + name + " | City: " + city)
-	}
 	defer db.Close()
-	http.Redirect(w, r, "/", 301)
+	if r.Method != "PUT" {
+		problem(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	var s models.Student
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	s.GradYear, s.GradeLevel, err = cohortOf(s.GradYear, s.GradeLevel, time.Now())
+	if err != nil {
+		invalid(w, err)
+		return
+	}
+	id := mux.Vars(r)["studentId"]
+	nId, err := strconv.Atoi(id)
+	if err != nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	if ok, err := studentInSchool(db, tenantOf(r).SchoolID, nId); err != nil || !ok {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	insForm, err := db.Prepare("UPDATE leaderboard.students SET firstName=?, lastName=?, gpa=?, credits=?, sport=?, grad_year=?, grade_level=?, " +
+		"archived_at=IF(? IS NULL, NULL, COALESCE(archived_at, ?)), updated_at=NOW() WHERE id=? AND school_id=?")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	archived := graduatedAt(s.GradYear, s.GradeLevel, time.Now())
+	if _, err := insForm.Exec(s.FirstName, s.LastName, s.GPA, s.Credits, s.Sport, s.GradYear, s.GradeLevel, archived, archived, id, tenantOf(r).SchoolID); err != nil {
+		serverError(w, err)
+		return
+	}
+	studentChanged(db, tenantOf(r).SchoolID, nId)
+	log.Println("UPDATE: ID: " + id + " | First Name: " + s.FirstName + " | Last Name: " + s.LastName)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 /******************************************************************************/
 
 func DeleteStudent(w http.ResponseWriter, r *http.Request) {
 	db := dbConn()
-	stu := r.URL.Query().Get("id")
-	delForm, err := db.Prepare("DELETE FROM leaderboard.students WHERE id=?")
+	defer db.Close()
+	stu := mux.Vars(r)["studentId"]
+	delForm, err := db.Prepare("DELETE FROM leaderboard.students WHERE id=? AND school_id=?")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	res, err := delForm.Exec(stu, tenantOf(r).SchoolID)
 	if err != nil {
-		panic(err.Error())
+		serverError(w, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	if id, err := strconv.Atoi(stu); err == nil {
+		studentChanged(db, tenantOf(r).SchoolID, id)
 	}
-	_, _ = delForm.Exec(stu)
 	log.Println("DELETE")
-	defer db.Close()
-	http.Redirect(w, r, "/", 301)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 /******************************************************************************/
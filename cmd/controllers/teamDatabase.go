@@ -0,0 +1,406 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var teamLevels = map[string]bool{
+	models.LevelVarsity:       true,
+	models.LevelJuniorVarsity: true,
+	models.LevelFreshman:      true,
+}
+
+// loadTeams returns the teams matching the optional where clause together
+// with their roster of student IDs.
+func loadTeams(db *sql.DB, where string, args ...interface{}) ([]*models.Team, error) {
+	query := "SELECT id, school_id, name, sport, level FROM leaderboard.teams"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := make([]*models.Team, 0)
+	byID := make(map[int]*models.Team)
+	for rows.Next() {
+		team := &models.Team{Members: make([]int, 0)}
+		if err := rows.Scan(&team.ID, &team.SchoolID, &team.Name, &team.Sport, &team.Level); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+		byID[team.ID] = team
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(teams) == 0 {
+		return teams, nil
+	}
+
+	ids := make([]interface{}, 0, len(teams))
+	for _, team := range teams {
+		ids = append(ids, team.ID)
+	}
+	members, err := db.Query("SELECT team_id, student_id FROM leaderboard.team_members WHERE team_id IN (?"+
+		repeatPlaceholders(len(ids)-1)+") ORDER BY student_id", ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+	for members.Next() {
+		var teamID, studentID int
+		if err := members.Scan(&teamID, &studentID); err != nil {
+			return nil, err
+		}
+		if team, ok := byID[teamID]; ok {
+			team.Members = append(team.Members, studentID)
+		}
+	}
+	return teams, members.Err()
+}
+
+// teamFilter builds the where clause for the caller's school and the sport
+// and level query parameters.
+func teamFilter(r *http.Request) (string, []interface{}) {
+	clauses := []string{"school_id = ?"}
+	args := []interface{}{tenantOf(r).SchoolID}
+	if sport := r.URL.Query().Get("sport"); sport != "" {
+		clauses = append(clauses, "sport = ?")
+		args = append(args, sport)
+	}
+	if level := r.URL.Query().Get("level"); level != "" {
+		clauses = append(clauses, "level = ?")
+		args = append(args, strings.ToLower(level))
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+/******************************************************************************/
+
+func IndexTeams(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	where, args := teamFilter(r)
+	teams, err := loadTeams(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, teams, func(i int) []interface{} { return []interface{}{teams[i].ID} }, []bool{false})
+}
+
+/******************************************************************************/
+
+func FetchTeam(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	teams, err := loadTeams(db, "id = ? AND school_id = ?", mux.Vars(r)["teamId"], tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(teams) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, teams[0])
+}
+
+/******************************************************************************/
+
+func InsertTeam(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var team models.Team
+	if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
+		invalid(w, err)
+		return
+	}
+	team.Level = strings.ToLower(team.Level)
+	if missing := required("name", team.Name, "sport", team.Sport); len(missing) > 0 {
+		invalidFields(w, missing...)
+		return
+	}
+	if !teamLevels[team.Level] {
+		invalidField(w, "level", "unknown level "+strconv.Quote(team.Level))
+		return
+	}
+
+	res, err := db.Exec("INSERT INTO leaderboard.teams(school_id, name, sport, level) VALUES(?, ?, ?, ?)",
+		tenantOf(r).SchoolID, team.Name, team.Sport, team.Level)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	team.ID = int(id)
+
+	members := team.Members
+	team.Members = make([]int, 0)
+	for _, studentID := range members {
+		added, err := addTeamMember(db, tenantOf(r).SchoolID, team.ID, studentID)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		if added {
+			team.Members = append(team.Members, studentID)
+		}
+	}
+	boardsChanged(tenantOf(r).SchoolID)
+	log.Println("INSERT TEAM: " + team.Name + " | Sport: " + team.Sport + " | Level: " + team.Level)
+	writeJSON(w, http.StatusCreated, team)
+}
+
+/******************************************************************************/
+
+func DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM leaderboard.teams WHERE id=? AND school_id=?",
+		mux.Vars(r)["teamId"], tenantOf(r).SchoolID); err != nil {
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenantOf(r).SchoolID)
+	log.Println("DELETE TEAM")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************/
+
+// TeamRoster lists a team's members with their eligibility at the team's
+// level.
+func TeamRoster(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	school := tenantOf(r).SchoolID
+	teams, err := loadTeams(db, "id = ? AND school_id = ?", mux.Vars(r)["teamId"], school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(teams) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	stus, err := loadStudents(db,
+		"school_id = ? AND id IN (SELECT student_id FROM leaderboard.team_members WHERE team_id = ?) ORDER BY lastName, id",
+		school, teams[0].ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	ev, err := loadEligibility(db, school)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	roster := make([]*models.RosterEntry, 0, len(stus))
+	for _, stu := range stus {
+		roster = append(roster, &models.RosterEntry{Student: stu, Eligibility: ev.forTeam(stu, teams[0])})
+	}
+	writePage(w, r, roster, func(i int) []interface{} {
+		return []interface{}{strings.ToLower(roster[i].Student.LastName), roster[i].Student.ID}
+	}, []bool{false, false})
+}
+
+// addTeamMember adds a student to a team, provided both belong to school.
+func addTeamMember(db *sql.DB, school int, teamID interface{}, studentID interface{}) (bool, error) {
+	res, err := db.Exec("INSERT IGNORE INTO leaderboard.team_members(team_id, student_id) "+
+		"SELECT t.id, s.id FROM leaderboard.teams t JOIN leaderboard.students s ON s.school_id = t.school_id "+
+		"WHERE t.id = ? AND s.id = ? AND t.school_id = ?", teamID, studentID, school)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func AddTeamMember(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var body struct {
+		StudentID int `json:"student_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalid(w, err)
+		return
+	}
+	if _, err := addTeamMember(db, tenantOf(r).SchoolID, mux.Vars(r)["teamId"], body.StudentID); err != nil {
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenantOf(r).SchoolID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	vars := mux.Vars(r)
+	if _, err := db.Exec("DELETE tm FROM leaderboard.team_members tm JOIN leaderboard.teams t ON t.id = tm.team_id "+
+		"WHERE tm.team_id=? AND tm.student_id=? AND t.school_id=?",
+		vars["teamId"], vars["studentId"], tenantOf(r).SchoolID); err != nil {
+		serverError(w, err)
+		return
+	}
+	boardsChanged(tenantOf(r).SchoolID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/******************************************************************************/
+
+// forTeam returns a student's eligibility to play for a team. It is judged
+// against the team's sport and level, whatever the student's own sport
+// column says.
+func (ev *eligibilityEvaluator) forTeam(stu *models.Student, team *models.Team) *models.Eligibility {
+	athlete := *stu
+	athlete.Sport = team.Sport
+	return ev.evaluate(&athlete, team.Level)
+}
+
+// rosterGPAs returns the GPAs of each team's members, by team ID, for
+// aggregateGPA: all of them for the mean and median, and for the minimum
+// only those eligible to play for the team. Students on probation are still
+// eligible, and students in a sport without rules always are. Graduates
+// left on a roster are not counted.
+func rosterGPAs(db *sql.DB, school int, teams []*models.Team) (map[int]map[string][]float64, error) {
+	stus, err := loadStudents(db, currentStudents, school)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := loadEligibility(db, school)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.Student)
+	for _, stu := range stus {
+		byID[stu.ID] = stu
+	}
+
+	gpas := make(map[int]map[string][]float64)
+	for _, team := range teams {
+		all, eligible := make([]float64, 0, len(team.Members)), make([]float64, 0, len(team.Members))
+		for _, id := range team.Members {
+			stu, ok := byID[id]
+			if !ok {
+				continue
+			}
+			all = append(all, float64(stu.GPA))
+			if e := ev.forTeam(stu, team); e == nil || e.Status != models.EligibilityIneligible {
+				eligible = append(eligible, float64(stu.GPA))
+			}
+		}
+		gpas[team.ID] = map[string][]float64{
+			models.AggregateMean:    all,
+			models.AggregateMedian:  all,
+			models.AggregateMinimum: eligible,
+		}
+	}
+	return gpas, nil
+}
+
+// aggregateGPA collapses a roster's GPAs into one score. The minimum
+// aggregate, given only the eligible members' GPAs, ranks a team by its
+// weakest eligible member, i.e. the GPA every athlete who may play is
+// guaranteed to clear.
+func aggregateGPA(gpas []float64, aggregate string) float64 {
+	if len(gpas) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), gpas...)
+	sort.Float64s(sorted)
+
+	switch aggregate {
+	case models.AggregateMedian:
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	case models.AggregateMinimum:
+		return sorted[0]
+	default:
+		var sum float64
+		for _, gpa := range sorted {
+			sum += gpa
+		}
+		return sum / float64(len(sorted))
+	}
+}
+
+func TeamLeaderboard(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	aggregate := r.URL.Query().Get("aggregate")
+	if aggregate == "" {
+		aggregate = models.AggregateMean
+	}
+	if aggregate != models.AggregateMean && aggregate != models.AggregateMedian && aggregate != models.AggregateMinimum {
+		invalidField(w, "aggregate", "must be one of mean, median or minimum")
+		return
+	}
+
+	where, args := teamFilter(r)
+	teams, err := loadTeams(db, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	gpas, err := rosterGPAs(db, tenantOf(r).SchoolID, teams)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	standings := make([]*models.TeamStanding, 0)
+	for _, team := range teams {
+		members := gpas[team.ID][aggregate]
+		if len(members) == 0 {
+			continue
+		}
+		standings = append(standings, &models.TeamStanding{
+			Team:      team,
+			Aggregate: aggregate,
+			Score:     aggregateGPA(members, aggregate),
+		})
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].Team.ID < standings[j].Team.ID
+	})
+	for i, standing := range standings {
+		standing.Rank = i + 1
+		if i > 0 && standing.Score == standings[i-1].Score {
+			standing.Rank = standings[i-1].Rank
+		}
+	}
+	writePage(w, r, standings, func(i int) []interface{} {
+		return []interface{}{standings[i].Score, standings[i].Team.ID}
+	}, []bool{true, false})
+}
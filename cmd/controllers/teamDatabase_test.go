@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"leaderboard-bk/cmd/models"
+	"testing"
+)
+
+func TestAggregateGPA(t *testing.T) {
+	tests := []struct {
+		gpas      []float64
+		aggregate string
+		want      float64
+	}{
+		{nil, models.AggregateMean, 0},
+		{[]float64{3, 4, 2}, models.AggregateMean, 3},
+		{[]float64{3, 4, 2}, models.AggregateMedian, 3},
+		{[]float64{3, 4, 2, 1}, models.AggregateMedian, 2.5},
+		{[]float64{3, 4, 2}, models.AggregateMinimum, 2},
+		{[]float64{3.5}, models.AggregateMinimum, 3.5},
+	}
+	for _, tt := range tests {
+		if got := aggregateGPA(tt.gpas, tt.aggregate); got != tt.want {
+			t.Errorf("aggregateGPA(%v, %s) = %v, want %v", tt.gpas, tt.aggregate, got, tt.want)
+		}
+	}
+}
+
+func TestEligibilityForTeam(t *testing.T) {
+	ev := &eligibilityEvaluator{
+		rules: map[int][]*models.EligibilityRule{1: {
+			{ID: 1, Sport: "soccer", MinGPA: 2},
+			{ID: 2, Sport: "soccer", Level: models.LevelVarsity, MinGPA: 2.5, GraceTerms: 1},
+		}},
+		terms: map[int][]*models.TermRecord{
+			// Most recent first: two terms below varsity's minimum.
+			3: {{GPA: 2.2}, {GPA: 2.3}, {GPA: 3}},
+		},
+		levels: make(map[int]string),
+	}
+	varsity := &models.Team{Sport: "soccer", Level: models.LevelVarsity}
+	jv := &models.Team{Sport: "soccer", Level: models.LevelJuniorVarsity}
+	tennis := &models.Team{Sport: "tennis", Level: models.LevelVarsity}
+	tests := []struct {
+		name string
+		stu  *models.Student
+		team *models.Team
+		// "" when the student is not subject to a rule.
+		want string
+	}{
+		{"above the level's minimum", &models.Student{ID: 1, SchoolID: 1, GPA: 3}, varsity, models.EligibilityEligible},
+		{"in the grace term", &models.Student{ID: 2, SchoolID: 1, GPA: 2.4}, varsity, models.EligibilityProbation},
+		{"past the grace terms", &models.Student{ID: 3, SchoolID: 1, GPA: 2.2}, varsity, models.EligibilityIneligible},
+		{"sport-wide rule at other levels", &models.Student{ID: 3, SchoolID: 1, GPA: 2.2}, jv, models.EligibilityEligible},
+		{"judged by the team's sport", &models.Student{ID: 3, SchoolID: 1, GPA: 1, Sport: "soccer"}, tennis, ""},
+		{"own sport ignored", &models.Student{ID: 3, SchoolID: 1, GPA: 1, Sport: "tennis"}, jv, models.EligibilityIneligible},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ev.forTeam(tt.stu, tt.team)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("forTeam = nil, want %s", tt.want)
+			case got != nil && got.Status != tt.want:
+				t.Errorf("forTeam = %s %v, want %s", got.Status, got.Reasons, tt.want)
+			}
+		})
+	}
+}
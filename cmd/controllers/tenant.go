@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"context"
+	"leaderboard-bk/cmd/models"
+	"net/http"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of r scoped to the given tenant. Every handler in
+// this package reads its school from the request rather than trusting the body.
+func WithTenant(r *http.Request, tenant *models.Tenant) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+}
+
+// tenantOf returns the tenant the request was scoped to. Requests that were
+// not routed through the tenant middleware get an empty tenant, which matches
+// no school.
+func tenantOf(r *http.Request) *models.Tenant {
+	if tenant, ok := r.Context().Value(tenantKey{}).(*models.Tenant); ok {
+		return tenant
+	}
+	return &models.Tenant{}
+}
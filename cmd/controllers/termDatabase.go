@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// loadTerms returns the term records matching the where clause, most recent
+// term first.
+func loadTerms(db *sql.DB, where string, args ...interface{}) ([]*models.TermRecord, error) {
+	rows, err := db.Query("SELECT id, student_id, term, gpa, credits, ends_on FROM leaderboard.student_terms WHERE "+
+		where+" ORDER BY ends_on DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := make([]*models.TermRecord, 0)
+	for rows.Next() {
+		term := new(models.TermRecord)
+		var endsOn mysql.NullTime
+		if err := rows.Scan(&term.ID, &term.StudentID, &term.Term, &term.GPA, &term.Credits, &endsOn); err != nil {
+			return nil, err
+		}
+		term.EndsOn = endsOn.Time
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+// termsByStudent groups term records by student, keeping the most recent
+// term first.
+func termsByStudent(terms []*models.TermRecord) map[int][]*models.TermRecord {
+	grouped := make(map[int][]*models.TermRecord)
+	for _, term := range terms {
+		grouped[term.StudentID] = append(grouped[term.StudentID], term)
+	}
+	return grouped
+}
+
+/******************************************************************************/
+
+func IndexStudentTerms(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	terms, err := loadTerms(db, "student_id = ? AND school_id = ?", mux.Vars(r)["studentId"], tenantOf(r).SchoolID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, terms, func(i int) []interface{} {
+		return []interface{}{micros(terms[i].EndsOn), terms[i].ID}
+	}, []bool{true, true})
+}
+
+// InsertStudentTerm records (or corrects) a student's result for one term.
+// `ends_on` is a plain date and orders the terms.
+func InsertStudentTerm(w http.ResponseWriter, r *http.Request) {
+	db := dbConn()
+	defer db.Close()
+
+	var body struct {
+		Term    string  `json:"term"`
+		GPA     float32 `json:"gpa"`
+		Credits float32 `json:"credits"`
+		EndsOn  string  `json:"ends_on"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalid(w, err)
+		return
+	}
+	if body.Term == "" {
+		invalidField(w, "term", "is required")
+		return
+	}
+	endsOn, err := time.Parse("2006-01-02", body.EndsOn)
+	if err != nil {
+		invalidField(w, "ends_on", "must be a date such as 2020-06-15")
+		return
+	}
+
+	studentID, err := strconv.Atoi(mux.Vars(r)["studentId"])
+	if err != nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	if ok, err := studentInSchool(db, tenantOf(r).SchoolID, studentID); err != nil || !ok {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	if _, err := db.Exec("INSERT INTO leaderboard.student_terms(school_id, student_id, term, gpa, credits, ends_on) "+
+		"VALUES(?, ?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE gpa = VALUES(gpa), credits = VALUES(credits), ends_on = VALUES(ends_on)",
+		tenantOf(r).SchoolID, studentID, body.Term, body.GPA, body.Credits, endsOn); err != nil {
+		serverError(w, err)
+		return
+	}
+	touchStudent(db, tenantOf(r).SchoolID, studentID)
+	studentChanged(db, tenantOf(r).SchoolID, studentID)
+	log.Println("INSERT TERM: Student: " + strconv.Itoa(studentID) + " | Term: " + body.Term)
+	writeJSON(w, http.StatusCreated, &models.TermRecord{
+		StudentID: studentID,
+		Term:      body.Term,
+		GPA:       body.GPA,
+		Credits:   body.Credits,
+		EndsOn:    endsOn,
+	})
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"leaderboard-bk/cmd/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// boardTieBreakers declares the tie-breaker chain each built-in board uses
+// unless the request overrides it with `tiebreak`.
+var boardTieBreakers = map[string][]string{
+	"gpa":         {models.TieBreakCredits, models.TieBreakUpdated},
+	"district":    {models.TieBreakCredits, models.TieBreakUpdated},
+	"improvement": {models.TieBreakCredits},
+	"sport_stats": {},
+}
+
+// tieBreakers compares two students on one criterion, returning a negative
+// number when a should be placed first.
+var tieBreakers = map[string]func(a, b *models.Student) int{
+	models.TieBreakCredits: func(a, b *models.Student) int {
+		return compareFloats(float64(b.Credits), float64(a.Credits))
+	},
+	models.TieBreakUpdated: func(a, b *models.Student) int {
+		switch {
+		case a.UpdatedAt.After(b.UpdatedAt):
+			return -1
+		case b.UpdatedAt.After(a.UpdatedAt):
+			return 1
+		}
+		return 0
+	},
+	models.TieBreakLastName: func(a, b *models.Student) int {
+		return strings.Compare(strings.ToLower(a.LastName), strings.ToLower(b.LastName))
+	},
+	models.TieBreakStudentID: func(a, b *models.Student) int {
+		return a.ID - b.ID
+	},
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// parseTieBreakers parses a comma separated tie-breaker chain given as param.
+// "none" is the empty chain.
+func parseTieBreakers(param, s string) ([]string, error) {
+	chain := make([]string, 0)
+	if s == "none" {
+		return chain, nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := tieBreakers[name]; !ok {
+			return nil, &QueryError{param, -1, "unknown tie-breaker " + strconv.Quote(name)}
+		}
+		if seen[name] {
+			return nil, &QueryError{param, -1, "tie-breaker " + strconv.Quote(name) + " appears twice"}
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+	return chain, nil
+}
+
+// tieBreakerChain returns the chain for a board: the `tiebreak` query
+// parameter when given, otherwise the board's declared chain.
+func tieBreakerChain(r *http.Request, board string) ([]string, error) {
+	if s := r.URL.Query().Get("tiebreak"); s != "" {
+		return parseTieBreakers("tiebreak", s)
+	}
+	return boardTieBreakers[board], nil
+}
+
+// compareEntries orders two entries by score and then by the chain. It also
+// reports what decided the order: "score", a tie-breaker, or "tie".
+func compareEntries(a, b *models.LeaderboardEntry, lowerIsBetter bool, chain []string) (int, string) {
+	cmp := compareFloats(b.Score, a.Score)
+	if lowerIsBetter {
+		cmp = -cmp
+	}
+	if cmp != 0 {
+		return cmp, models.DecidedByScore
+	}
+	if a.Student == nil || b.Student == nil {
+		return 0, models.DecidedByTie
+	}
+	for _, name := range chain {
+		if cmp := tieBreakers[name](a.Student, b.Student); cmp != 0 {
+			return cmp, name
+		}
+	}
+	return 0, models.DecidedByTie
+}
+
+// applyTiePolicy renumbers ranked entries according to the tie policy.
+// rankEntries already numbers them for the shared policy.
+func applyTiePolicy(entries []*models.LeaderboardEntry, policy string) {
+	for i, entry := range entries {
+		switch policy {
+		case models.TiePolicyUnique:
+			entry.Rank = i + 1
+		case models.TiePolicyDense:
+			entry.Rank = 1
+			if i > 0 {
+				entry.Rank = entries[i-1].Rank
+				if entry.DecidedBy != models.DecidedByTie {
+					entry.Rank++
+				}
+			}
+		}
+	}
+}
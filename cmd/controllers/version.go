@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"expvar"
+	"leaderboard-bk/cmd/models"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// API versions. Version 1 is the legacy surface under /api, kept as it was
+// for the clients already using it; version 2 fixes its response shapes.
+const (
+	apiV1     = 1
+	apiV2     = 2
+	latestAPI = apiV2
+)
+
+// Requests served, by version and by "v<n> METHOD /route", published at
+// /api/metrics.
+var (
+	requestsByVersion = expvar.NewMap("api_requests_by_version")
+	requestsByRoute   = expvar.NewMap("api_requests_by_route")
+)
+
+// Media types asking for a version, e.g. application/vnd.leaderboard.v2+json.
+var versionedMediaType = regexp.MustCompile(`^application/vnd\.leaderboard\.v([0-9]+)\+json$`)
+
+// Paths naming their version, e.g. /api/v2/students.
+var versionedPath = regexp.MustCompile(`^/api/v([0-9]+)(/.*)?$`)
+
+type versionKey struct{}
+
+// apiRequest is what Versioned records about a request before routing it.
+type apiRequest struct {
+	version int
+	// The URL as the client sent it, before the version was taken out of
+	// the path.
+	url *url.URL
+	// Whether the version was given in the path.
+	prefixed bool
+}
+
+// acceptedVersion reads the version asked for in an Accept header, either as
+// a vendor media type or as a version parameter of application/json. It
+// returns 0 when none is asked for.
+func acceptedVersion(accept string) (int, error) {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if m := versionedMediaType.FindStringSubmatch(mediaType); m != nil {
+			return strconv.Atoi(m[1])
+		}
+		if mediaType != "application/json" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "version" {
+				return strconv.Atoi(strings.Trim(kv[1], `"`))
+			}
+		}
+	}
+	return 0, nil
+}
+
+// Versioned works out which version of the API a request is for and routes
+// it to the shared routes under /api. A version in the path (/api/v2/...)
+// wins over one asked for in the Accept header; requests naming neither get
+// version 1. Unknown versions are answered 404 in the path and 406 in the
+// header.
+func Versioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &apiRequest{version: apiV1, url: r.URL}
+		routed := r.URL
+		if m := versionedPath.FindStringSubmatch(r.URL.Path); m != nil {
+			v, err := strconv.Atoi(m[1])
+			if err != nil || v < apiV1 || v > latestAPI {
+				problem(w, http.StatusNotFound, "unknown API version")
+				return
+			}
+			req.version, req.prefixed = v, true
+			rewritten := *r.URL
+			rewritten.Path, rewritten.RawPath = "/api"+m[2], ""
+			routed = &rewritten
+		} else if v, err := acceptedVersion(r.Header.Get("Accept")); err != nil || v > latestAPI || (v != 0 && v < apiV1) {
+			problem(w, http.StatusNotAcceptable, "supported API versions are 1 to "+strconv.Itoa(latestAPI))
+			return
+		} else if v != 0 {
+			req.version = v
+		}
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("API-Version", strconv.Itoa(req.version))
+		r = r.WithContext(context.WithValue(r.Context(), versionKey{}, req))
+		r.URL = routed
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestOf returns what Versioned recorded about r. Requests that did not
+// pass through it are version 1 requests as sent.
+func requestOf(r *http.Request) *apiRequest {
+	if req, ok := r.Context().Value(versionKey{}).(*apiRequest); ok {
+		return req
+	}
+	return &apiRequest{version: apiV1, url: r.URL}
+}
+
+// apiVersion returns the version of the API a request is for.
+func apiVersion(r *http.Request) int {
+	return requestOf(r).version
+}
+
+// clientURL returns a copy of the URL the client sent, version and all, for
+// links back to the same resource.
+func clientURL(r *http.Request) *url.URL {
+	u := *requestOf(r).url
+	return &u
+}
+
+// apiPath returns an /api path as a client of the request's version should
+// see it: under /api/v2 when the request named its version in the path.
+func apiPath(r *http.Request, path string) string {
+	if req := requestOf(r); req.prefixed {
+		return "/api/v" + strconv.Itoa(req.version) + strings.TrimPrefix(path, "/api")
+	}
+	return path
+}
+
+// Version2 matches routes that only exist from version 2 on.
+func Version2(r *http.Request, _ *mux.RouteMatch) bool {
+	return apiVersion(r) >= apiV2
+}
+
+// CountVersions counts the requests served by version and route, and marks
+// version 1 responses as deprecated, linking to the same route in the
+// latest version.
+func CountVersions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := "v" + strconv.Itoa(apiVersion(r))
+		requestsByVersion.Add(version, 1)
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				requestsByRoute.Add(version+" "+r.Method+" "+path, 1)
+			}
+		}
+		if apiVersion(r) < latestAPI {
+			w.Header().Set("Deprecation", "true")
+			successor := "/api/v" + strconv.Itoa(latestAPI) + strings.TrimPrefix(r.URL.Path, "/api")
+			w.Header().Add("Link", "<"+successor+">; rel=\"successor-version\"")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Metrics serves the request counters and the rest of expvar's variables.
+// Only administrators may read them.
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	if tenantOf(r).Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
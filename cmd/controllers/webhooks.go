@@ -0,0 +1,688 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"leaderboard-bk/cmd/models"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How many attempts a delivery gets before it is dead-lettered.
+	maxDeliveryAttempts = 10
+	// The wait after the first failed attempt, doubled after every other.
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+	// How many due deliveries are sent in one go.
+	deliveryBatch = 100
+	// Secrets shorter or longer than these are refused.
+	minSecretLength = 16
+	maxSecretLength = 128
+)
+
+// Deliveries are POSTed with these headers. The signature is
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">", keyed with
+// the webhook's secret; receivers should also refuse old timestamps.
+const (
+	signatureHeader = "X-Leaderboard-Signature"
+	eventHeader     = "X-Leaderboard-Event"
+	deliveryHeader  = "X-Leaderboard-Delivery"
+)
+
+// Addresses no webhook may be delivered to, besides loopback, link-local
+// and unspecified ones: private networks, shared address space and the
+// like, which would let a webhook reach services behind the server.
+var internalNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.0.0.0/24",
+		"192.168.0.0/16", "198.18.0.0/15", "240.0.0.0/4", "fc00::/7",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicAddress reports whether a webhook may be delivered to ip.
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range internalNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var errInternalAddress = errors.New("refusing to deliver to an internal address")
+
+// Deliveries connect only to public addresses. The check is made on the
+// address dialled, after the host is resolved, so a name that resolves to
+// an internal address, now or after the webhook was created, is refused.
+// Proxies from the environment are not used, as they would be dialled
+// instead.
+var webhookDialer = &net.Dialer{
+	Timeout: 5 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+			return errInternalAddress
+		}
+		return nil
+	},
+}
+
+// Redirects are not followed: a delivery is signed for the URL it was
+// meant for.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         webhookDialer.DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// The webhooks watching each board, kept in step with the table by
+// watchWebhooks.
+var webhooks = struct {
+	sync.Mutex
+	byBoard map[feedKey][]*models.Webhook
+}{byBoard: make(map[feedKey][]*models.Webhook)}
+
+// Wakes RunWebhooks when deliveries are queued.
+var webhookWake = make(chan struct{}, 1)
+
+func wakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// signDelivery returns the signature header of a body sent at t.
+func signDelivery(secret string, t time.Time, body []byte) string {
+	stamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stamp + "."))
+	mac.Write(body)
+	return "t=" + stamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay is how long to wait after the given number of failed
+// attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// splitList reads a comma separated column.
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// loadWebhooks returns the school's webhooks matching the where clause,
+// secrets included.
+func loadWebhooks(db *sql.DB, school int, where string, args ...interface{}) ([]*models.Webhook, error) {
+	query := "SELECT id, url, events, boards, secret, created_by, created_at FROM leaderboard.webhooks WHERE school_id = ?"
+	if where != "" {
+		query += " AND " + where
+	}
+	rows, err := db.Query(query+" ORDER BY id", append([]interface{}{school}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]*models.Webhook, 0)
+	for rows.Next() {
+		hook := new(models.Webhook)
+		var events, boards string
+		var createdAt mysql.NullTime
+		if err := rows.Scan(&hook.ID, &hook.URL, &events, &boards, &hook.Secret, &hook.CreatedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		hook.Events, hook.Boards, hook.CreatedAt = splitList(events), splitList(boards), createdAt.Time
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// requestedWebhook loads the webhook named by the route, or nil.
+func requestedWebhook(db *sql.DB, r *http.Request) (*models.Webhook, error) {
+	hooks, err := loadWebhooks(db, tenantOf(r).SchoolID, "id = ?", mux.Vars(r)["webhookId"])
+	if err != nil || len(hooks) == 0 {
+		return nil, err
+	}
+	return hooks[0], nil
+}
+
+// watchWebhooks reloads which boards every school's webhooks watch,
+// keeping the feeds of those boards running and letting the others stop.
+func watchWebhooks(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, school_id, events, boards FROM leaderboard.webhooks ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byBoard := make(map[feedKey][]*models.Webhook)
+	for rows.Next() {
+		hook := new(models.Webhook)
+		var school int
+		var events, boards string
+		if err := rows.Scan(&hook.ID, &school, &events, &boards); err != nil {
+			return err
+		}
+		hook.Events = splitList(events)
+		for _, slug := range splitList(boards) {
+			key := feedKey{school, slug}
+			byBoard[key] = append(byBoard[key], hook)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	webhooks.Lock()
+	previous := webhooks.byBoard
+	webhooks.byBoard = byBoard
+	webhooks.Unlock()
+	for key := range byBoard {
+		if previous[key] == nil {
+			pinFeed(key.school, key.slug, true)
+		}
+	}
+	for key := range previous {
+		if byBoard[key] == nil {
+			pinFeed(key.school, key.slug, false)
+		}
+	}
+	return nil
+}
+
+// notifyWebhooks queues a delivery of every change on a board to each
+// webhook that watches the board for that kind of change.
+func notifyWebhooks(school int, slug string, changes []*models.BoardChange) {
+	webhooks.Lock()
+	hooks := webhooks.byBoard[feedKey{school, slug}]
+	webhooks.Unlock()
+	if len(hooks) == 0 || len(changes) == 0 {
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	now := time.Now()
+	queued := false
+	for _, change := range changes {
+		event := "board." + changeKind(change)
+		payload, _ := json.Marshal(&models.WebhookEvent{Event: event, SchoolID: school, Board: slug, OccurredAt: now, Change: change})
+		for _, hook := range hooks {
+			if !containsString(hook.Events, event) {
+				continue
+			}
+			if _, err := db.Exec("INSERT INTO leaderboard.webhook_deliveries(webhook_id, school_id, event, payload, next_attempt_at) "+
+				"VALUES(?, ?, ?, ?, NOW())", hook.ID, school, event, string(payload)); err != nil {
+				log.Println("WEBHOOK: queueing " + event + " for " + strconv.Itoa(hook.ID) + " | " + err.Error())
+				continue
+			}
+			queued = true
+		}
+	}
+	if queued {
+		wakeWebhooks()
+	}
+}
+
+// A delivery that is due, with what is needed to send it.
+type dueDelivery struct {
+	id, webhookID, attempts int
+	event, payload          string
+	url, secret             string
+}
+
+// deliver POSTs a delivery once. Any 2xx response delivers it.
+func deliver(d *dueDelivery) (int, error) {
+	body := []byte(d.payload)
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "leaderboard-webhooks")
+	req.Header.Set(eventHeader, d.event)
+	req.Header.Set(deliveryHeader, strconv.Itoa(d.id))
+	req.Header.Set(signatureHeader, signDelivery(d.secret, time.Now(), body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("unexpected response " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// lastError is why an attempt failed, as last_error keeps it: valid UTF-8,
+// since the status line comes from the receiver, and cut to the column's
+// 255 characters.
+func lastError(err error) string {
+	reason := []rune(strings.ToValidUTF8(err.Error(), "\uFFFD"))
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	return string(reason)
+}
+
+// recordAttempt stores how a delivery attempt went, scheduling the next one
+// or dead-lettering the delivery when it has run out of attempts.
+func recordAttempt(db *sql.DB, d *dueDelivery, code int, err error) error {
+	if err == nil {
+		_, err := db.Exec("UPDATE leaderboard.webhook_deliveries SET status = 'delivered', attempts = attempts + 1, "+
+			"last_status_code = ?, last_error = '', next_attempt_at = NULL, delivered_at = NOW() WHERE id = ?", code, d.id)
+		return err
+	}
+	reason := lastError(err)
+	attempts := d.attempts + 1
+	if attempts >= maxDeliveryAttempts {
+		log.Println("WEBHOOK: delivery " + strconv.Itoa(d.id) + " to " + strconv.Itoa(d.webhookID) + " is dead | " + reason)
+		_, err := db.Exec("UPDATE leaderboard.webhook_deliveries SET status = 'dead', attempts = ?, last_status_code = ?, "+
+			"last_error = ?, next_attempt_at = NULL WHERE id = ?", attempts, code, reason, d.id)
+		return err
+	}
+	_, err = db.Exec("UPDATE leaderboard.webhook_deliveries SET attempts = ?, last_status_code = ?, last_error = ?, "+
+		"next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id = ?",
+		attempts, code, reason, int(retryDelay(attempts)/time.Second), d.id)
+	return err
+}
+
+// deliverDue attempts a batch of the deliveries that are due, oldest
+// first, and returns how many there were. Once one of a webhook's
+// deliveries fails the rest of its batch waits, so that a receiver that is
+// down is not sent each of them in turn.
+func deliverDue(db *sql.DB) (int, error) {
+	rows, err := db.Query("SELECT d.id, d.webhook_id, d.attempts, d.event, d.payload, w.url, w.secret "+
+		"FROM leaderboard.webhook_deliveries d JOIN leaderboard.webhooks w ON w.id = d.webhook_id "+
+		"WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() ORDER BY d.id LIMIT ?", deliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+	due := make([]*dueDelivery, 0)
+	for rows.Next() {
+		d := new(dueDelivery)
+		if err := rows.Scan(&d.id, &d.webhookID, &d.attempts, &d.event, &d.payload, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	failing := make(map[int]bool)
+	for _, d := range due {
+		if failing[d.webhookID] {
+			continue
+		}
+		code, err := deliver(d)
+		if err != nil {
+			failing[d.webhookID] = true
+		}
+		if err := recordAttempt(db, d, code, err); err != nil {
+			return len(due), err
+		}
+	}
+	return len(due), nil
+}
+
+// RunWebhooks sends webhook deliveries as they are queued, and retries
+// the failed ones when they are due, checking at least every interval; it
+// never returns.
+func RunWebhooks(interval time.Duration) {
+	watching := false
+	for {
+		db := dbConn()
+		if !watching {
+			if err := watchWebhooks(db); err != nil {
+				log.Println("WEBHOOK: " + err.Error())
+			} else {
+				watching = true
+			}
+		}
+		n, err := deliverDue(db)
+		if err != nil {
+			log.Println("WEBHOOK: " + err.Error())
+		}
+		db.Close()
+		if err == nil && n == deliveryBatch {
+			continue
+		}
+		select {
+		case <-webhookWake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// rewatchWebhooks is watchWebhooks after a change through the API, where a
+// failure is only logged: the change itself has been made.
+func rewatchWebhooks(db *sql.DB) {
+	if err := watchWebhooks(db); err != nil {
+		log.Println("WEBHOOK: " + err.Error())
+	}
+}
+
+/******************************************************************************/
+
+// IndexWebhooks lists the school's webhooks, without their secrets.
+// Only administrators manage webhooks.
+func IndexWebhooks(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	hooks, err := loadWebhooks(db, tenant.SchoolID, "")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	for _, hook := range hooks {
+		hook.Secret = ""
+	}
+	writePage(w, r, hooks, func(i int) []interface{} { return []interface{}{hooks[i].ID} }, []bool{false})
+}
+
+// CreateWebhook subscribes a URL to changes on some of the school's boards.
+// A secret is generated unless one is given; either way this is the only
+// response that shows it.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	var body struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Boards []string `json:"boards"`
+		Secret string   `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		invalid(w, err)
+		return
+	}
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalidField(w, "url", "must be an absolute http or https URL")
+		return
+	}
+	// Names are checked again on every delivery, as what they resolve to
+	// may change.
+	if ip := net.ParseIP(u.Hostname()); (ip != nil && !publicAddress(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+		invalidField(w, "url", "must not be an internal address")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	for _, slug := range body.Boards {
+		board, err := resolveBoard(db, tenant.SchoolID, slug)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if board == nil {
+			invalidField(w, "boards", "unknown board "+slug)
+			return
+		}
+	}
+	secret := body.Secret
+	if secret == "" {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	res, err := db.Exec("INSERT INTO leaderboard.webhooks(school_id, url, events, boards, secret, created_by) "+
+		"VALUES(?, ?, ?, ?, ?, ?)", tenant.SchoolID, body.URL, strings.Join(body.Events, ","), strings.Join(body.Boards, ","),
+		secret, tenant.Username)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	rewatchWebhooks(db)
+	log.Println("CREATE WEBHOOK: " + body.URL + " | By: " + tenant.Username)
+
+	hooks, err := loadWebhooks(db, tenant.SchoolID, "id = ?", id)
+	if err != nil || len(hooks) == 0 {
+		log.Println("CREATE WEBHOOK: reading back webhook failed")
+		problem(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Location", apiPath(r, "/api/webhooks/"+strconv.FormatInt(id, 10)))
+	writeJSON(w, http.StatusCreated, hooks[0])
+}
+
+// FetchWebhook serves a webhook, without its secret.
+func FetchWebhook(w http.ResponseWriter, r *http.Request) {
+	if tenantOf(r).Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	hook, err := requestedWebhook(db, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if hook == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// DeleteWebhook unsubscribes a webhook, dropping its delivery log and any
+// deliveries still to be sent.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM leaderboard.webhooks WHERE school_id = ? AND id = ?", tenant.SchoolID, mux.Vars(r)["webhookId"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	rewatchWebhooks(db)
+	log.Println("DELETE WEBHOOK: " + mux.Vars(r)["webhookId"] + " | By: " + tenant.Username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadDeliveries returns a webhook's deliveries matching the where clause,
+// newest first.
+func loadDeliveries(db *sql.DB, webhook int, where string, args ...interface{}) ([]*models.WebhookDelivery, error) {
+	query := "SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, last_status_code, last_error, " +
+		"created_at, delivered_at FROM leaderboard.webhook_deliveries WHERE webhook_id = ?"
+	if where != "" {
+		query += " AND " + where
+	}
+	rows, err := db.Query(query+" ORDER BY id DESC", append([]interface{}{webhook}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for rows.Next() {
+		d := new(models.WebhookDelivery)
+		var payload string
+		var nextAttemptAt, createdAt, deliveredAt mysql.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &nextAttemptAt,
+			&d.LastStatusCode, &d.LastError, &createdAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = createdAt.Time
+		if nextAttemptAt.Valid {
+			d.NextAttemptAt = &nextAttemptAt.Time
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		d.Payload = new(models.WebhookEvent)
+		if err := json.Unmarshal([]byte(payload), d.Payload); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// IndexWebhookDeliveries is a webhook's delivery log, newest first,
+// optionally with one `status`.
+func IndexWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if tenantOf(r).Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	hook, err := requestedWebhook(db, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if hook == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	where, args := "", []interface{}{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		where, args = "status = ?", append(args, status)
+	}
+	deliveries, err := loadDeliveries(db, hook.ID, where, args...)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writePage(w, r, deliveries, func(i int) []interface{} { return []interface{}{deliveries[i].ID} }, []bool{true})
+}
+
+// RetryWebhookDelivery gives a dead delivery a fresh set of attempts,
+// starting now.
+func RetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+	if tenant.Role != models.RoleAdmin {
+		problem(w, http.StatusForbidden, "")
+		return
+	}
+	db := dbConn()
+	defer db.Close()
+
+	hook, err := requestedWebhook(db, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if hook == nil {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	deliveries, err := loadDeliveries(db, hook.ID, "id = ?", mux.Vars(r)["deliveryId"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(deliveries) == 0 {
+		problem(w, http.StatusNotFound, "")
+		return
+	}
+	res, err := db.Exec("UPDATE leaderboard.webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW() "+
+		"WHERE id = ? AND status = 'dead'", deliveries[0].ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		problem(w, http.StatusConflict, "only dead deliveries can be retried")
+		return
+	}
+	wakeWebhooks()
+	log.Println("RETRY WEBHOOK DELIVERY: " + strconv.Itoa(deliveries[0].ID) + " | By: " + tenant.Username)
+
+	if deliveries, err = loadDeliveries(db, hook.ID, "id = ?", deliveries[0].ID); err != nil || len(deliveries) == 0 {
+		log.Println("RETRY WEBHOOK DELIVERY: reading back delivery failed")
+		problem(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries[0])
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		// Loopback and unspecified.
+		{"127.0.0.1", false},
+		{"127.255.255.254", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"0.1.2.3", false},
+		// Private networks.
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		// Link-local, cloud metadata included.
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		// IPv6 unique local addresses.
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+		// IPv4 addresses written as IPv6.
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		// Multicast and reserved.
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"255.255.255.255", false},
+		// Public.
+		{"8.8.8.8", true},
+		{"172.15.255.255", true},
+		{"172.32.0.1", true},
+		{"100.128.0.1", true},
+		{"2606:4700:4700::1111", true},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		if got := publicAddress(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookDialerRefusesInternalAddresses(t *testing.T) {
+	tests := []struct {
+		address string
+		err     error
+	}{
+		{"127.0.0.1:80", errInternalAddress},
+		{"[::1]:443", errInternalAddress},
+		{"169.254.169.254:80", errInternalAddress},
+		{"[fd00::1]:443", errInternalAddress},
+		{"[fe80::1%eth0]:443", errInternalAddress},
+		{"8.8.8.8:443", nil},
+		{"[2606:4700:4700::1111]:443", nil},
+	}
+	for _, tt := range tests {
+		if err := webhookDialer.Control("tcp", tt.address, nil); err != tt.err {
+			t.Errorf("Control(%s) = %v, want %v", tt.address, err, tt.err)
+		}
+	}
+}
+
+// A name resolving to an internal address is refused when it is delivered
+// to, not only when the webhook is created.
+func TestDeliverRefusesLoopback(t *testing.T) {
+	delivered := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered = true
+	}))
+	defer srv.Close()
+	for _, url := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		code, err := deliver(&dueDelivery{id: 1, url: url, event: "board.rank", secret: "secret", payload: "{}"})
+		if code != 0 || err == nil || !strings.Contains(err.Error(), errInternalAddress.Error()) {
+			t.Errorf("deliver(%s) = %d, %v, want the internal address refused", url, code, err)
+		}
+	}
+	if delivered {
+		t.Error("a delivery reached the loopback server")
+	}
+}
+
+func TestSignDelivery(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := []byte(`{"event":"board.rank"}`)
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"whsec_0123456789abcdef", "t=1767225600,v1=8946259f4274557ebb2551345e7139bacf4235a3f554f542ce2b3a2c82b42ad9"},
+		{"another-secret-value", "t=1767225600,v1=47df41afa9ea4d8ad89304a9781a2523f144755d6bc4456be08de990d94515b0"},
+	}
+	for _, tt := range tests {
+		if got := signDelivery(tt.secret, at, body); got != tt.want {
+			t.Errorf("signDelivery(%q) = %s, want %s", tt.secret, got, tt.want)
+		}
+	}
+	if signDelivery("whsec_0123456789abcdef", at.Add(time.Second), body) == tests[0].want {
+		t.Error("signature does not cover the timestamp")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{6, 16 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestLastError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   string
+		runes int
+	}{
+		{"short", "connection refused", 18},
+		{"long ascii", strings.Repeat("x", 300), 255},
+		{"long multi-byte", strings.Repeat("é", 300), 255},
+		// A run of invalid bytes becomes one replacement character.
+		{"invalid utf-8", "unexpected response 500 \xff\xfe", 25},
+	}
+	for _, tt := range tests {
+		got := lastError(errors.New(tt.err))
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) != tt.runes {
+			t.Errorf("%s: lastError = %q, want %d valid characters", tt.name, got, tt.runes)
+		}
+	}
+}
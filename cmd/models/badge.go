@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Kinds of badge rule.
+const (
+	// Awarded for every term at or above MinGPA with at least MinCredits.
+	BadgeTermGPA = "term_gpa"
+	// Awarded when a student reaches Terms consecutive terms at or above MinGPA.
+	BadgeStreak = "streak"
+	// Awarded when term GPA rises by at least MinImprovement over the
+	// previous term, both terms carrying at least MinCredits.
+	BadgeImprovement = "improvement"
+)
+
+// A declarative award rule. Which fields apply depends on Kind.
+type BadgeRule struct {
+	Kind           string  `json:"kind"`
+	MinGPA         float32 `json:"min_gpa,omitempty"`
+	MinCredits     float32 `json:"min_credits,omitempty"`
+	Terms          int     `json:"terms,omitempty"`
+	MinImprovement float32 `json:"min_improvement,omitempty"`
+}
+
+type Badge struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Rule        BadgeRule `json:"rule"`
+}
+
+// A badge awarded to a student for a term.
+type BadgeAward struct {
+	ID        int       `json:"id"`
+	StudentID int       `json:"student_id"`
+	Badge     string    `json:"badge"`
+	Term      string    `json:"term"`
+	AwardedAt time.Time `json:"awarded_at"`
+}
+
+// A student holding a badge, with their awards of it.
+type BadgeHolder struct {
+	Student *Student      `json:"student"`
+	Awards  []*BadgeAward `json:"awards"`
+}
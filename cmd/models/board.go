@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Who may view a saved leaderboard.
+const (
+	// Any account of the school; published standings of public boards are
+	// also shown to anonymous readers, with the school's privacy settings.
+	VisibilityPublic = "public"
+	// Any signed-in account of the school.
+	VisibilityStaff = "staff"
+	// Administrators only.
+	VisibilityAdmin = "admin"
+)
+
+// How ranks are numbered for entries the tie-breakers cannot separate.
+const (
+	// Tied entries share a rank and the next rank is skipped (1, 2, 2, 4).
+	TiePolicyShared = "shared"
+	// Tied entries share a rank and no rank is skipped (1, 2, 2, 3).
+	TiePolicyDense = "dense"
+	// Every entry gets its own rank (1, 2, 3, 4).
+	TiePolicyUnique = "unique"
+)
+
+// Restricts which students appear on a leaderboard. Empty fields do not
+// restrict anything.
+type BoardFilter struct {
+	Sports     []string `json:"sports,omitempty"`
+	TeamIDs    []int    `json:"team_ids,omitempty"`
+	Levels     []string `json:"levels,omitempty"`
+	MinGPA     *float64 `json:"min_gpa,omitempty"`
+	MaxGPA     *float64 `json:"max_gpa,omitempty"`
+	MinCredits *float64 `json:"min_credits,omitempty"`
+	GradYears  []int    `json:"grad_years,omitempty"`
+	// An expression in the listing query language, e.g. `gpa > 3.0`.
+	Query string `json:"query,omitempty"`
+}
+
+// A leaderboard definition.
+type Leaderboard struct {
+	Slug   string      `json:"slug"`
+	Name   string      `json:"name"`
+	Filter BoardFilter `json:"filter"`
+	// "gpa", "credits" or "stat:<id>".
+	Score string `json:"score"`
+	// "desc" (highest first) or "asc".
+	Direction   string   `json:"direction"`
+	TieBreakers []string `json:"tie_breakers"`
+	TiePolicy   string   `json:"tie_policy"`
+	Visibility  string   `json:"visibility"`
+	BuiltIn     bool     `json:"built_in,omitempty"`
+}
+
+// A leaderboard definition together with its current standings, or with
+// the standings published at PublishedAt.
+type BoardStandings struct {
+	Board       *Leaderboard        `json:"board"`
+	PublishedAt *time.Time          `json:"published_at,omitempty"`
+	Entries     []*LeaderboardEntry `json:"entries"`
+}
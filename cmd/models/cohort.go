@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// The highest grade level; students in it graduate at the next promotion.
+const FinalGradeLevel = 12
+
+// The students graduating in one year.
+type Cohort struct {
+	GradYear   int `json:"grad_year"`
+	GradeLevel int `json:"grade_level,omitempty"`
+	Students   int `json:"students"`
+	// Whether the cohort has graduated and its students are archived.
+	Graduated bool `json:"graduated"`
+	// The class-year board, until the cohort graduates.
+	Board string `json:"board,omitempty"`
+}
+
+// One run of the annual promotion.
+type Promotion struct {
+	ID int `json:"id"`
+	// The year in which the school year that ended was completed.
+	SchoolYear int `json:"school_year"`
+	// Students moved up a grade, and students archived as graduates.
+	Promoted   int       `json:"promoted"`
+	Graduated  int       `json:"graduated"`
+	PromotedBy string    `json:"promoted_by,omitempty"`
+	PromotedAt time.Time `json:"promoted_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// One student's side of a comparison. Scores and ranks are keyed by board
+// slug or score name ("gpa", "credits", "stat:<id>").
+type StudentComparison struct {
+	Student *Student           `json:"student"`
+	Scores  map[string]float64 `json:"scores"`
+	Ranks   map[string]int     `json:"ranks"`
+	Terms   []*TermRecord      `json:"terms"`
+}
+
+// One team's side of a comparison. Scores and ranks are keyed by aggregate.
+type TeamComparison struct {
+	Team   *Team              `json:"team"`
+	Scores map[string]float64 `json:"scores"`
+	Ranks  map[string]int     `json:"ranks"`
+	Terms  []*TermAverage     `json:"terms"`
+}
+
+// A team's mean GPA for a term, over the members with a record for it.
+type TermAverage struct {
+	Term    string    `json:"term"`
+	EndsOn  time.Time `json:"ends_on"`
+	GPA     float64   `json:"gpa"`
+	Members int       `json:"members"`
+}
+
+// How a compared student or team differs from the baseline, the first one
+// compared: its value minus the baseline's, for every score, rank and term
+// both have. A negative rank difference means a better placing.
+type ComparisonDelta struct {
+	ID     int                `json:"id"`
+	Scores map[string]float64 `json:"scores"`
+	Ranks  map[string]int     `json:"ranks"`
+	Terms  map[string]float64 `json:"terms"`
+}
+
+type Comparison struct {
+	Baseline    int                  `json:"baseline"`
+	Students    []*StudentComparison `json:"students,omitempty"`
+	Teams       []*TeamComparison    `json:"teams,omitempty"`
+	Differences []*ComparisonDelta   `json:"differences"`
+}
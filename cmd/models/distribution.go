@@ -0,0 +1,32 @@
+package models
+
+// Summary statistics of a set of scores.
+type Distribution struct {
+	Group string `json:"group,omitempty"`
+	// Set when grouping by team, as team names need not be unique.
+	TeamID      int                `json:"team_id,omitempty"`
+	Count       int                `json:"count"`
+	Mean        float64            `json:"mean"`
+	Median      float64            `json:"median"`
+	StdDev      float64            `json:"std_dev"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	Histogram   []*Bucket          `json:"histogram"`
+}
+
+// A histogram bucket counting scores in [Low, High). The last bucket also
+// includes its upper bound.
+type Bucket struct {
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+	Count int     `json:"count"`
+}
+
+// The response of the distribution endpoint.
+type DistributionReport struct {
+	Score   string          `json:"score"`
+	GroupBy string          `json:"group_by,omitempty"`
+	Overall *Distribution   `json:"overall"`
+	Groups  []*Distribution `json:"groups,omitempty"`
+}
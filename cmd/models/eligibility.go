@@ -0,0 +1,41 @@
+package models
+
+// Eligibility statuses, from best to worst.
+const (
+	EligibilityEligible   = "eligible"
+	EligibilityProbation  = "probation"
+	EligibilityIneligible = "ineligible"
+)
+
+// An academic eligibility rule for a sport. An empty Level applies to every
+// level of the sport that has no rule of its own.
+type EligibilityRule struct {
+	ID         int     `json:"id"`
+	Sport      string  `json:"sport"`
+	Level      string  `json:"level"`
+	MinGPA     float32 `json:"min_gpa"`
+	MinCredits float32 `json:"min_credits"`
+	// Consecutive terms a student may spend below MinGPA on probation
+	// before becoming ineligible.
+	GraceTerms int `json:"grace_terms"`
+}
+
+// The outcome of evaluating a student against the rule for their sport.
+type Eligibility struct {
+	Status  string   `json:"status"`
+	RuleID  int      `json:"rule_id"`
+	Level   string   `json:"level,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// A student on a team roster, with their eligibility for the team.
+type RosterEntry struct {
+	Student     *Student     `json:"student"`
+	Eligibility *Eligibility `json:"eligibility,omitempty"`
+}
+
+// A row of the eligibility report.
+type EligibilityReportEntry struct {
+	Student     *Student     `json:"student"`
+	Eligibility *Eligibility `json:"eligibility"`
+}
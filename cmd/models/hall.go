@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// All-time record keys. Each sport stat also has a record, keyed
+// "stat:<id>".
+const (
+	// The highest GPA earned in a single term on a full course load.
+	RecordTermGPA = "term_gpa"
+	// The most consecutive terms on the honor roll.
+	RecordHonorRollStreak = "honor_roll_streak"
+)
+
+// A student who finished near the top of a board in an archived season.
+type SeasonWinner struct {
+	ID         int       `json:"id"`
+	Season     string    `json:"season"`
+	Board      string    `json:"board"`
+	Rank       int       `json:"rank"`
+	StudentID  int       `json:"student_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Score      float64   `json:"score"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// A request to archive the leaders of a season.
+type SeasonArchive struct {
+	Season string `json:"season"`
+	// Slugs of the boards to archive; every board when empty.
+	Boards []string `json:"boards"`
+	// How many places to keep; ties for the last place are all kept.
+	Top int `json:"top"`
+}
+
+// An all-time record, set by the named student. Detail says what the
+// record was set with, such as the term or the stat.
+type Record struct {
+	ID         int       `json:"id"`
+	Key        string    `json:"key"`
+	Name       string    `json:"name"`
+	StudentID  int       `json:"student_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Value      float64   `json:"value"`
+	Detail     string    `json:"detail,omitempty"`
+	AchievedOn time.Time `json:"achieved_on"`
+	SetAt      time.Time `json:"set_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// A single row of a student leaderboard.
+type LeaderboardEntry struct {
+	Rank        int          `json:"rank"`
+	Student     *Student     `json:"student"`
+	Score       float64      `json:"score"`
+	Eligibility *Eligibility `json:"eligibility,omitempty"`
+	// What placed this entry below the previous one: "score", the
+	// tie-breaker that separated them, or "tie". Empty for the first entry.
+	DecidedBy string `json:"decided_by,omitempty"`
+	// Board specific figures the score was derived from.
+	Details map[string]float64 `json:"details,omitempty"`
+}
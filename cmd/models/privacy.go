@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// How students are named on public leaderboards when they have no alias.
+const (
+	// "J. S."
+	NameStyleInitials = "initials"
+	// "John S."
+	NameStyleFirstName = "first_name"
+)
+
+// A school's rules for public leaderboards.
+type PrivacySettings struct {
+	NameStyle string `json:"name_style"`
+	// Scores are shown as bands this wide instead of exact values.
+	BandWidth float64 `json:"band_width"`
+	// Boards with fewer students are not shown, and bands with fewer
+	// students are not shown for them.
+	MinGroupSize int `json:"min_group_size"`
+}
+
+// A student's choices about public leaderboards.
+type StudentPrivacy struct {
+	StudentID int    `json:"student_id"`
+	OptOut    bool   `json:"opt_out"`
+	Alias     string `json:"alias,omitempty"`
+}
+
+// A score band, from Low up to but not including High.
+type ScoreBand struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// A leaderboard entry as the public sees it. Band is left out when too few
+// students share it.
+type PublicEntry struct {
+	Rank int        `json:"rank"`
+	Name string     `json:"name"`
+	Band *ScoreBand `json:"band,omitempty"`
+}
+
+// A published board as the public sees it.
+type PublicStandings struct {
+	Slug        string         `json:"slug"`
+	Name        string         `json:"name"`
+	PublishedAt *time.Time     `json:"published_at,omitempty"`
+	Entries     []*PublicEntry `json:"entries"`
+}
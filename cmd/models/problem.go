@@ -0,0 +1,22 @@
+package models
+
+// An RFC 7807 problem detail, the body of every error response.
+type Problem struct {
+	// A URI reference naming the kind of problem; "about:blank" when the
+	// status code says all there is to say.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// The parameters or body fields that failed validation.
+	Errors []*FieldError `json:"errors,omitempty"`
+}
+
+// A parameter or body field that failed validation.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+	// The 1-based character position of the fault within the field's value,
+	// when it is known.
+	Position int `json:"position,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Publication statuses. A draft is either published or discarded; a
+// published publication is retired when another one replaces it.
+const (
+	PublicationDraft     = "draft"
+	PublicationPublished = "published"
+	PublicationRetired   = "retired"
+	PublicationDiscarded = "discarded"
+)
+
+// A frozen copy of a board's standings, reviewed before the public sees it.
+// Entries are left out of listings.
+type Publication struct {
+	ID          int                 `json:"id"`
+	Board       *Leaderboard        `json:"board"`
+	Status      string              `json:"status"`
+	Note        string              `json:"note,omitempty"`
+	CreatedBy   string              `json:"created_by"`
+	CreatedAt   time.Time           `json:"created_at"`
+	ApprovedBy  string              `json:"approved_by,omitempty"`
+	PublishedAt *time.Time          `json:"published_at,omitempty"`
+	Entries     []*LeaderboardEntry `json:"entries,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// A student's rank on a board at a point in time. When a long range is
+// downsampled, each point stands for every recorded rank up to At since the
+// previous point: Rank and Score are the latest of them, BestRank and
+// WorstRank the range they covered.
+type RankPoint struct {
+	At        time.Time `json:"at"`
+	Rank      int       `json:"rank"`
+	Score     float64   `json:"score"`
+	BestRank  int       `json:"best_rank"`
+	WorstRank int       `json:"worst_rank"`
+	Samples   int       `json:"samples"`
+}
+
+// The rank time series of one student on one board.
+type RankHistory struct {
+	Board     string       `json:"board"`
+	StudentID int          `json:"student_id"`
+	Points    []*RankPoint `json:"points"`
+}
@@ -0,0 +1,32 @@
+package models
+
+// Roles an account may hold within its school. Accounts without a role are
+// ordinary staff.
+const (
+	RoleAdmin = "admin"
+	// May approve leaderboard publications, as may administrators.
+	RolePublisher = "publisher"
+)
+
+type School struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// An account that may sign in. Every account belongs to a school; district
+// accounts may additionally read leaderboards across every school.
+type Account struct {
+	Password string
+	SchoolID int
+	District bool
+	Role     string
+}
+
+// The tenant a request is scoped to, taken from the JWT (or, on the public
+// routes, the `school` query parameter).
+type Tenant struct {
+	Username string `json:"username,omitempty"`
+	SchoolID int    `json:"school_id"`
+	District bool   `json:"district"`
+	Role     string `json:"role,omitempty"`
+}
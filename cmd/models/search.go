@@ -0,0 +1,8 @@
+package models
+
+// A student matched by a name search, with how closely the name matched
+// (1 is an exact match).
+type SearchResult struct {
+	Student *Student `json:"student"`
+	Score   float64  `json:"score"`
+}
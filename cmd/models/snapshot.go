@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// A point-in-time copy of the GPA and credits of every student in a school.
+type Snapshot struct {
+	ID       int       `json:"id"`
+	Label    string    `json:"label"`
+	TakenAt  time.Time `json:"taken_at"`
+	Students int       `json:"students"`
+}
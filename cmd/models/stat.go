@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// A performance statistic tracked for a sport, e.g. the 100m time in track
+// (lower is better) or points per game in basketball (higher is better).
+type StatDefinition struct {
+	ID    int    `json:"id"`
+	Sport string `json:"sport"`
+	Name  string `json:"name"`
+	Unit  string `json:"unit"`
+	// Number of decimal places values are reported with.
+	Precision     int  `json:"precision"`
+	LowerIsBetter bool `json:"lower_is_better"`
+}
+
+// A single recorded performance.
+type StatEntry struct {
+	ID         int       `json:"id"`
+	StatID     int       `json:"stat_id"`
+	StudentID  int       `json:"student_id"`
+	Value      float64   `json:"value"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+// Kinds of event on a board's event stream.
+const (
+	// The full standings, sent first when a stream cannot be resumed.
+	BoardEventSnapshot = "snapshot"
+	// A student joined the board.
+	BoardEventEntry = "entry"
+	// A student's rank or score changed.
+	BoardEventRank = "rank"
+	// A student left the board.
+	BoardEventRemoval = "removal"
+)
+
+// A change to one student's place on a board.
+type BoardChange struct {
+	StudentID int `json:"student_id"`
+	// The student's entry as it now stands; left out of removals.
+	Entry *LeaderboardEntry `json:"entry,omitempty"`
+	// Where the student stood before; left out of new entries.
+	PreviousRank  int      `json:"previous_rank,omitempty"`
+	PreviousScore *float64 `json:"previous_score,omitempty"`
+}
@@ -0,0 +1,33 @@
+package models
+
+// Team levels recognised by the roster endpoints.
+const (
+	LevelVarsity       = "varsity"
+	LevelJuniorVarsity = "jv"
+	LevelFreshman      = "freshman"
+)
+
+// Ways of collapsing a roster's GPAs into a single team score.
+const (
+	AggregateMean   = "mean"
+	AggregateMedian = "median"
+	// The lowest GPA among the members eligible to play for the team.
+	AggregateMinimum = "minimum"
+)
+
+type Team struct {
+	ID       int    `json:"id"`
+	SchoolID int    `json:"school_id"`
+	Name     string `json:"name"`
+	Sport    string `json:"sport"`
+	Level    string `json:"level"`
+	Members  []int  `json:"members"`
+}
+
+// A single row of a team leaderboard.
+type TeamStanding struct {
+	Rank      int     `json:"rank"`
+	Team      *Team   `json:"team"`
+	Aggregate string  `json:"aggregate"`
+	Score     float64 `json:"score"`
+}
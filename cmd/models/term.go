@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// The result of a single academic term for a student.
+type TermRecord struct {
+	ID        int       `json:"id"`
+	StudentID int       `json:"student_id"`
+	Term      string    `json:"term"`
+	GPA       float32   `json:"gpa"`
+	Credits   float32   `json:"credits"`
+	EndsOn    time.Time `json:"ends_on"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// Tie-breakers that can order students sharing a score.
+const (
+	// More credits earned first.
+	TieBreakCredits = "credits"
+	// Most recently updated record first.
+	TieBreakUpdated = "updated"
+	// Last name, alphabetically.
+	TieBreakLastName = "last_name"
+	// Lowest student ID first.
+	TieBreakStudentID = "student_id"
+)
+
+// Values of LeaderboardEntry.DecidedBy that are not tie-breakers.
+const (
+	// The entry ranks below the previous one on score alone.
+	DecidedByScore = "score"
+	// Nothing separates the entry from the previous one; they share a rank.
+	DecidedByTie = "tie"
+)
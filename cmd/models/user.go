@@ -27,6 +27,9 @@ type User struct {
 // We add jwt.StandardClaims as an embedded type, to provide fields like expiry time
 type Claims struct {
 	Username string `json:"username"`
+	SchoolID int `json:"school_id"`
+	District bool `json:"district,omitempty"`
+	Role string `json:"role,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -34,14 +37,28 @@ type Student_test struct {
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
 	GPA int `json:"gpa"`
+	Credits float32 `json:"credits"`
 	Sport string `json:"sport"`
+	GradYear int `json:"grad_year,omitempty"`
+	GradeLevel int `json:"grade_level,omitempty"`
 }
 
 type Student struct {
 	ID int `json:"id"`
+	SchoolID int `json:"school_id"`
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
 	GPA float32 `json:"gpa"`
+	Credits float32 `json:"credits"`
 	Sport string `json:"sport"`
+	// The year the student graduates and their current grade, or 0 when
+	// unknown. Either is worked out from the other when only one is given.
+	GradYear int `json:"grad_year,omitempty"`
+	GradeLevel int `json:"grade_level,omitempty"`
+	// Set when the student graduated; archived students leave the
+	// leaderboards but keep their records.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
 	CreatedAt time.Time `json:"t_stamp"`
+	// When the student's record, terms or stats last changed.
+	UpdatedAt time.Time `json:"updated_at"`
 }
\ No newline at end of file
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Events a webhook can subscribe to, one for each kind of change on a
+// board's event stream.
+const (
+	WebhookBoardEntry   = "board.entry"
+	WebhookBoardRank    = "board.rank"
+	WebhookBoardRemoval = "board.removal"
+)
+
+// Delivery statuses. A pending delivery is retried until it is delivered
+// or runs out of attempts and is dead.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// A subscription of another system to changes on some of the school's
+// boards. The secret signs every delivery; it is only shown when the
+// webhook is created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Boards    []string  `json:"boards"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// The body POSTed to a webhook's URL.
+type WebhookEvent struct {
+	Event      string       `json:"event"`
+	SchoolID   int          `json:"school_id"`
+	Board      string       `json:"board"`
+	OccurredAt time.Time    `json:"occurred_at"`
+	Change     *BoardChange `json:"change"`
+}
+
+// One event sent, or to be sent, to a webhook, and how sending it went.
+type WebhookDelivery struct {
+	ID        int           `json:"id"`
+	WebhookID int           `json:"webhook_id"`
+	Event     string        `json:"event"`
+	Payload   *WebhookEvent `json:"payload"`
+	Status    string        `json:"status"`
+	Attempts  int           `json:"attempts"`
+	// When the next attempt is due; left out once there are no more.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// The response to the last attempt: its status code, or why there was
+	// none.
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
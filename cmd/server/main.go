@@ -7,6 +7,7 @@ import (
 	"leaderboard-bk/cmd/models"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -16,18 +17,118 @@ import (
 
 var jwtKey = []byte("my_secret_key")
 
-var users = map[string]string{
-	"user1": "password1",
-	"user2": "password2",
+var users = map[string]models.Account{
+	"user1": {Password: "password1", SchoolID: 1, Role: models.RoleAdmin},
+	"user2": {Password: "password2", SchoolID: 1, District: true},
+	"user3": {Password: "password3", SchoolID: 1, Role: models.RolePublisher},
 }
 
 /*******************STUDENT API ROUTES****************************/
 type test_struct struct {FirstName string `json:"first_name"`}
 func StudentsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexStudents(w, r)}
 func CreateStudent(w http.ResponseWriter, r *http.Request) {controllers.InsertStudent(w, r)}
-func FetchStudent(w http.ResponseWriter, r *http.Request) {controllers.IndexStudents(w, r)}
-//func UpdateStudent(w http.ResponseWriter, r *http.Request) {controllers.EditOfStudent(w, r)}
+func FetchStudent(w http.ResponseWriter, r *http.Request) {controllers.FetchStudent(w, r)}
+func UpdateStudent(w http.ResponseWriter, r *http.Request) {controllers.UpdateStudent(w, r)}
 func DeleteStudent(w http.ResponseWriter, r *http.Request) {controllers.DeleteStudent(w, r)}
+func SearchStudents(w http.ResponseWriter, r *http.Request) {controllers.SearchStudents(w, r)}
+func AutocompleteStudents(w http.ResponseWriter, r *http.Request) {controllers.AutocompleteStudents(w, r)}
+/*****************************************************************/
+
+/*******************LEADERBOARD API ROUTES************************/
+func StudentLeaderboard(w http.ResponseWriter, r *http.Request) {controllers.StudentLeaderboard(w, r)}
+func DistrictLeaderboard(w http.ResponseWriter, r *http.Request) {controllers.DistrictLeaderboard(w, r)}
+func ImprovementLeaderboard(w http.ResponseWriter, r *http.Request) {controllers.ImprovementLeaderboard(w, r)}
+func SnapshotsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexSnapshots(w, r)}
+func CreateSnapshot(w http.ResponseWriter, r *http.Request) {controllers.InsertSnapshot(w, r)}
+func Distribution(w http.ResponseWriter, r *http.Request) {controllers.Distribution(w, r)}
+func StudentRankHistory(w http.ResponseWriter, r *http.Request) {controllers.StudentRankHistory(w, r)}
+func Compare(w http.ResponseWriter, r *http.Request) {controllers.Compare(w, r)}
+/*****************************************************************/
+
+/*******************PUBLICATION API ROUTES************************/
+func PublicationsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexPublications(w, r)}
+func CreatePublication(w http.ResponseWriter, r *http.Request) {controllers.CreatePublication(w, r)}
+func FetchPublication(w http.ResponseWriter, r *http.Request) {controllers.FetchPublication(w, r)}
+func ApprovePublication(w http.ResponseWriter, r *http.Request) {controllers.ApprovePublication(w, r)}
+func RollbackPublication(w http.ResponseWriter, r *http.Request) {controllers.RollbackPublication(w, r)}
+func DiscardPublication(w http.ResponseWriter, r *http.Request) {controllers.DiscardPublication(w, r)}
+func PublicLeaderboard(w http.ResponseWriter, r *http.Request) {controllers.PublicLeaderboard(w, r)}
+/*****************************************************************/
+
+/*******************PRIVACY API ROUTES****************************/
+func FetchPrivacySettings(w http.ResponseWriter, r *http.Request) {controllers.FetchPrivacySettings(w, r)}
+func UpdatePrivacySettings(w http.ResponseWriter, r *http.Request) {controllers.UpdatePrivacySettings(w, r)}
+func FetchStudentPrivacy(w http.ResponseWriter, r *http.Request) {controllers.FetchStudentPrivacy(w, r)}
+func UpdateStudentPrivacy(w http.ResponseWriter, r *http.Request) {controllers.UpdateStudentPrivacy(w, r)}
+/*****************************************************************/
+
+/*******************COHORT API ROUTES*****************************/
+func CohortsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexCohorts(w, r)}
+func PromotionsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexPromotions(w, r)}
+func PromoteSchool(w http.ResponseWriter, r *http.Request) {controllers.PromoteSchool(w, r)}
+/*****************************************************************/
+
+/*******************WEBHOOK API ROUTES****************************/
+func WebhooksIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexWebhooks(w, r)}
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {controllers.CreateWebhook(w, r)}
+func FetchWebhook(w http.ResponseWriter, r *http.Request) {controllers.FetchWebhook(w, r)}
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {controllers.DeleteWebhook(w, r)}
+func WebhookDeliveries(w http.ResponseWriter, r *http.Request) {controllers.IndexWebhookDeliveries(w, r)}
+func RetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {controllers.RetryWebhookDelivery(w, r)}
+/*****************************************************************/
+
+/*******************HALL OF FAME API ROUTES***********************/
+func RecordsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexRecords(w, r)}
+func RecordHistory(w http.ResponseWriter, r *http.Request) {controllers.RecordHistory(w, r)}
+func SeasonWinners(w http.ResponseWriter, r *http.Request) {controllers.IndexSeasonWinners(w, r)}
+func ArchiveSeason(w http.ResponseWriter, r *http.Request) {controllers.ArchiveSeason(w, r)}
+/*****************************************************************/
+
+/*******************TERM API ROUTES*******************************/
+func StudentTerms(w http.ResponseWriter, r *http.Request) {controllers.IndexStudentTerms(w, r)}
+func CreateStudentTerm(w http.ResponseWriter, r *http.Request) {controllers.InsertStudentTerm(w, r)}
+/*****************************************************************/
+
+/*******************ELIGIBILITY API ROUTES************************/
+func EligibilityRules(w http.ResponseWriter, r *http.Request) {controllers.IndexEligibilityRules(w, r)}
+func CreateEligibilityRule(w http.ResponseWriter, r *http.Request) {controllers.InsertEligibilityRule(w, r)}
+func DeleteEligibilityRule(w http.ResponseWriter, r *http.Request) {controllers.DeleteEligibilityRule(w, r)}
+func EligibilityReport(w http.ResponseWriter, r *http.Request) {controllers.EligibilityReport(w, r)}
+/*****************************************************************/
+
+/*******************BADGE API ROUTES******************************/
+func BadgesIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexBadges(w, r)}
+func CreateBadge(w http.ResponseWriter, r *http.Request) {controllers.InsertBadge(w, r)}
+func BadgeHolders(w http.ResponseWriter, r *http.Request) {controllers.BadgeHolders(w, r)}
+func StudentBadges(w http.ResponseWriter, r *http.Request) {controllers.StudentBadges(w, r)}
+/*****************************************************************/
+
+/*******************SPORT STAT API ROUTES************************/
+func StatDefinitions(w http.ResponseWriter, r *http.Request) {controllers.IndexStatDefinitions(w, r)}
+func CreateStatDefinition(w http.ResponseWriter, r *http.Request) {controllers.InsertStatDefinition(w, r)}
+func DeleteStatDefinition(w http.ResponseWriter, r *http.Request) {controllers.DeleteStatDefinition(w, r)}
+func StatEntries(w http.ResponseWriter, r *http.Request) {controllers.IndexStatEntries(w, r)}
+func CreateStatEntry(w http.ResponseWriter, r *http.Request) {controllers.InsertStatEntry(w, r)}
+func StatLeaderboard(w http.ResponseWriter, r *http.Request) {controllers.StatLeaderboard(w, r)}
+/*****************************************************************/
+
+/*******************SAVED BOARD API ROUTES************************/
+func BoardsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexBoards(w, r)}
+func FetchBoard(w http.ResponseWriter, r *http.Request) {controllers.FetchBoard(w, r)}
+func BoardEvents(w http.ResponseWriter, r *http.Request) {controllers.BoardEvents(w, r)}
+func SaveBoard(w http.ResponseWriter, r *http.Request) {controllers.SaveBoard(w, r)}
+func DeleteBoard(w http.ResponseWriter, r *http.Request) {controllers.DeleteBoard(w, r)}
+/*****************************************************************/
+
+/*******************TEAM API ROUTES*******************************/
+func TeamsIndex(w http.ResponseWriter, r *http.Request) {controllers.IndexTeams(w, r)}
+func CreateTeam(w http.ResponseWriter, r *http.Request) {controllers.InsertTeam(w, r)}
+func FetchTeam(w http.ResponseWriter, r *http.Request) {controllers.FetchTeam(w, r)}
+func DeleteTeam(w http.ResponseWriter, r *http.Request) {controllers.DeleteTeam(w, r)}
+func TeamRoster(w http.ResponseWriter, r *http.Request) {controllers.TeamRoster(w, r)}
+func AddTeamMember(w http.ResponseWriter, r *http.Request) {controllers.AddTeamMember(w, r)}
+func RemoveTeamMember(w http.ResponseWriter, r *http.Request) {controllers.RemoveTeamMember(w, r)}
+func TeamLeaderboard(w http.ResponseWriter, r *http.Request) {controllers.TeamLeaderboard(w, r)}
 /*****************************************************************/
 
 
@@ -38,29 +139,32 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
 		// If the structure of the body is wrong, return an HTTP error
-		w.WriteHeader(http.StatusBadRequest)
+		controllers.WriteProblem(w, http.StatusBadRequest, "the body must be JSON with a username and password")
 		return
 	}
 	//authy := queryAuth(creds)
 	//
 	//if authy {
 	// Get the expected password from our in memory map
-	expectedPassword, ok := users[creds.Username]
+	account, ok := users[creds.Username]
 
 	// If a password exists for the given user
 	// AND, if it is the same as the password we received, the we can move ahead
 	// if NOT, then we return an "Unauthorized" status
-	if !ok || expectedPassword != creds.Password {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !ok || account.Password != creds.Password {
+		controllers.WriteProblem(w, http.StatusUnauthorized, "unknown username or wrong password")
 		return
 	}
 
 	// Declare the expiration time of the token
 	// here, we have kept it as 5 minutes
 	expirationTime := time.Now().Add(5 * time.Minute)
-	// Create the JWT claims, which includes the username and expiry time
+	// Create the JWT claims, which includes the username, school and expiry time
 	claims := &models.Claims{
 		Username: creds.Username,
+		SchoolID: account.SchoolID,
+		District: account.District,
+		Role:     account.Role,
 		StandardClaims: jwt.StandardClaims{
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: expirationTime.Unix(),
@@ -69,15 +173,13 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 
 	// Declare the token with the algorithm used for signing, and the claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	log.Println(token)
 	// Create the JWT string
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
 		// If there is an error in creating the JWT return an internal server error
-		w.WriteHeader(http.StatusInternalServerError)
+		controllers.WriteProblem(w, http.StatusInternalServerError, "")
 		return
 	}
-	fmt.Printf(tokenString)
 
 	// Finally, we set the client cookie for "token" as the JWT we just generated
 	// we also set an expiry time which is the same as the token itself
@@ -97,15 +199,12 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 func Refresh(w http.ResponseWriter, r *http.Request) {
 	// (BEGIN) The code up until this point is the same as the first part of the `Welcome` route
 	c, err := r.Cookie("token")
-	fmt.Printf("1")
 	if err != nil {
-		fmt.Printf("2")
 		if err == http.ErrNoCookie {
-			fmt.Printf("no cookie")
-			w.WriteHeader(http.StatusUnauthorized)
+			controllers.WriteProblem(w, http.StatusUnauthorized, "sign in first")
 			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		controllers.WriteProblem(w, http.StatusBadRequest, "the token cookie is malformed")
 		return
 	}
 	tknStr := c.Value
@@ -114,17 +213,15 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 		return jwtKey, nil
 	})
 	if err != nil {
-		fmt.Printf("3")
 		if err == jwt.ErrSignatureInvalid {
-			w.WriteHeader(http.StatusUnauthorized)
+			controllers.WriteProblem(w, http.StatusUnauthorized, "the token is invalid")
 			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		controllers.WriteProblem(w, http.StatusBadRequest, "the token is malformed")
 		return
 	}
 	if !tkn.Valid {
-		fmt.Printf("4")
-		w.WriteHeader(http.StatusUnauthorized)
+		controllers.WriteProblem(w, http.StatusUnauthorized, "the token has expired")
 		return
 	}
 	// (END) The code up until this point is the same as the first part of the `Welcome` route
@@ -133,7 +230,7 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 	// In this case, a new token will only be issued if the old token is within
 	// 30 seconds of expiry. Otherwise, return a bad request status
 	if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > 30*time.Second {
-		w.WriteHeader(http.StatusBadRequest)
+		controllers.WriteProblem(w, http.StatusBadRequest, "tokens can only be refreshed in the last 30 seconds before they expire")
 		return
 	}
 
@@ -143,11 +240,10 @@ func Refresh(w http.ResponseWriter, r *http.Request) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		controllers.WriteProblem(w, http.StatusInternalServerError, "")
 		return
 	}
 
-	fmt.Printf(tokenString)
 
 	// Set the new token as the users `token` cookie
 	http.SetCookie(w, &http.Cookie{
@@ -163,11 +259,11 @@ func Welcome(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if err == http.ErrNoCookie {
 			// If the cookie is not set, return an unauthorized status
-			w.WriteHeader(http.StatusUnauthorized)
+			controllers.WriteProblem(w, http.StatusUnauthorized, "sign in first")
 			return
 		}
 		// For any other type of error, return a bad request status
-		w.WriteHeader(http.StatusBadRequest)
+		controllers.WriteProblem(w, http.StatusBadRequest, "the token cookie is malformed")
 		return
 	}
 
@@ -186,14 +282,14 @@ func Welcome(w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
-			w.WriteHeader(http.StatusUnauthorized)
+			controllers.WriteProblem(w, http.StatusUnauthorized, "the token is invalid")
 			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		controllers.WriteProblem(w, http.StatusBadRequest, "the token is malformed")
 		return
 	}
 	if !tkn.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
+		controllers.WriteProblem(w, http.StatusUnauthorized, "the token has expired")
 		return
 	}
 
@@ -203,25 +299,187 @@ func Welcome(w http.ResponseWriter, r *http.Request) {
 }
 /**************************************************************/
 
+/*******************TENANT SCOPING********************************/
+// tenantScope scopes every request to the school in the caller's token.
+// Callers without a token are turned away; the public routes are served by
+// publicScope instead.
+func tenantScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("token"); err == nil {
+			claims := &models.Claims{}
+			tkn, err := jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
+				return jwtKey, nil
+			})
+			if err != nil || !tkn.Valid {
+				controllers.WriteProblem(w, http.StatusUnauthorized, "the token is invalid or has expired")
+				return
+			}
+			next.ServeHTTP(w, controllers.WithTenant(r, &models.Tenant{
+				Username: claims.Username,
+				SchoolID: claims.SchoolID,
+				District: claims.District,
+				Role:     claims.Role,
+			}))
+			return
+		}
+		controllers.WriteProblem(w, http.StatusUnauthorized, "sign in first")
+	})
+}
 
+// publicScope serves the public routes to anyone, signed in or not. They are
+// read only and name the school being read with the `school` query
+// parameter; every caller sees the same privacy-preserving rendering.
+func publicScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			controllers.WriteProblem(w, http.StatusMethodNotAllowed, "")
+			return
+		}
+		school, err := strconv.Atoi(r.URL.Query().Get("school"))
+		if err != nil {
+			controllers.WriteProblem(w, http.StatusBadRequest, "school is required")
+			return
+		}
+		next.ServeHTTP(w, controllers.WithTenant(r, &models.Tenant{SchoolID: school}))
+	})
+}
+/*****************************************************************/
+
+/*******************RATE LIMITS***********************************/
+var (
+	// Signing in is limited by address, to slow down password guessing
+	signinLimit = &controllers.RateLimit{Name: "signin", Requests: 10, Per: time.Minute, By: controllers.ByIP}
+	// Checking and refreshing tokens happens before anyone is known, so it
+	// is limited by address too
+	sessionLimit = &controllers.RateLimit{Name: "session", Requests: 60, Per: time.Minute, By: controllers.ByIP}
+	// Integrations reading public boards identify themselves with an API
+	// key; however many keys they use, one address gets no more than this
+	publicKeyLimit = &controllers.RateLimit{Name: "public", Requests: 120, Per: time.Minute, By: controllers.ByAPIKey}
+	publicIPLimit  = &controllers.RateLimit{Name: "public-ip", Requests: 600, Per: time.Minute, By: controllers.ByIP}
+	// Signed-in users
+	readLimit  = &controllers.RateLimit{Name: "read", Requests: 300, Per: time.Minute, By: controllers.ByUser,
+		Methods: []string{http.MethodGet, http.MethodHead}}
+	writeLimit = &controllers.RateLimit{Name: "write", Requests: 60, Per: time.Minute, By: controllers.ByUser,
+		Methods: []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}}
+)
+/*****************************************************************/
 
 func main() {
 	// "Signin" and "Welcome" are the actions that we will implement
 	router := mux.NewRouter()
-	router.HandleFunc("/api/signin", Signin)
-	router.HandleFunc("/api/welcome", Welcome)
-	router.HandleFunc("/api/refresh", Refresh)
-	router.HandleFunc("/api/all_students", StudentsIndex)
-	router.HandleFunc("/api/students", CreateStudent).Methods(http.MethodPost)
-	router.HandleFunc("/api/students/{studentId}", FetchStudent).Methods(http.MethodGet)
-	//router.HandleFunc("/api/students/{studentId}", UpdateStudent).Methods(http.MethodPut)
-	router.HandleFunc("/api/students/{studentId}", DeleteStudent).Methods(http.MethodDelete)
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		controllers.WriteProblem(w, http.StatusNotFound, "")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		controllers.WriteProblem(w, http.StatusMethodNotAllowed, "")
+	})
+	// Count requests by API version and mark the legacy version deprecated
+	router.Use(controllers.CountVersions)
+	router.Handle("/api/signin", controllers.Limit(signinLimit)(controllers.ValidateRequest(http.HandlerFunc(Signin))))
+	router.Handle("/api/welcome", controllers.Limit(sessionLimit)(http.HandlerFunc(Welcome)))
+	router.Handle("/api/refresh", controllers.Limit(sessionLimit)(http.HandlerFunc(Refresh)))
+	router.HandleFunc("/api/openapi.json", controllers.ServeOpenAPI).Methods(http.MethodGet)
+	router.HandleFunc("/api/docs", controllers.ServeDocs).Methods(http.MethodGet)
+
+	// Anonymous readers only reach the public routes
+	public := router.PathPrefix("/api/public").Subrouter()
+	public.Use(controllers.Limit(publicKeyLimit, publicIPLimit), publicScope, controllers.ValidateRequest)
+	public.HandleFunc("/leaderboards/{slug}", PublicLeaderboard).Methods(http.MethodGet)
+
+	// Everything below is scoped to the caller's school. Leaderboard and
+	// student reads are cached until the school's data changes
+	api := router.NewRoute().Subrouter()
+	api.Use(tenantScope, controllers.Limit(readLimit, writeLimit), controllers.ValidateRequest)
+	api.Handle("/api/all_students", controllers.Cached(http.HandlerFunc(StudentsIndex)))
+	api.Handle("/api/students", controllers.Cached(http.HandlerFunc(StudentsIndex))).Methods(http.MethodGet).MatcherFunc(controllers.Version2)
+	api.HandleFunc("/api/students", CreateStudent).Methods(http.MethodPost)
+	api.HandleFunc("/api/students/search", SearchStudents).Methods(http.MethodGet)
+	api.HandleFunc("/api/students/autocomplete", AutocompleteStudents).Methods(http.MethodGet)
+	api.Handle("/api/students/{studentId}", controllers.Cached(http.HandlerFunc(FetchStudent))).Methods(http.MethodGet)
+	api.HandleFunc("/api/students/{studentId}", UpdateStudent).Methods(http.MethodPut)
+	api.HandleFunc("/api/students/{studentId}", DeleteStudent).Methods(http.MethodDelete)
+	api.HandleFunc("/api/students/{studentId}/terms", StudentTerms).Methods(http.MethodGet)
+	api.HandleFunc("/api/students/{studentId}/terms", CreateStudentTerm).Methods(http.MethodPost)
+	api.HandleFunc("/api/students/{studentId}/badges", StudentBadges).Methods(http.MethodGet)
+	api.HandleFunc("/api/students/{studentId}/rank_history", StudentRankHistory).Methods(http.MethodGet)
+	api.HandleFunc("/api/students/{studentId}/privacy", FetchStudentPrivacy).Methods(http.MethodGet)
+	api.HandleFunc("/api/students/{studentId}/privacy", UpdateStudentPrivacy).Methods(http.MethodPut)
+	api.HandleFunc("/api/privacy", FetchPrivacySettings).Methods(http.MethodGet)
+	api.HandleFunc("/api/privacy", UpdatePrivacySettings).Methods(http.MethodPut)
+	api.HandleFunc("/api/badges", BadgesIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/badges", CreateBadge).Methods(http.MethodPost)
+	api.HandleFunc("/api/badges/{badgeKey}/holders", BadgeHolders).Methods(http.MethodGet)
+	api.HandleFunc("/api/teams", TeamsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/teams", CreateTeam).Methods(http.MethodPost)
+	api.HandleFunc("/api/teams/{teamId}", FetchTeam).Methods(http.MethodGet)
+	api.HandleFunc("/api/teams/{teamId}", DeleteTeam).Methods(http.MethodDelete)
+	api.HandleFunc("/api/teams/{teamId}/members", TeamRoster).Methods(http.MethodGet)
+	api.HandleFunc("/api/teams/{teamId}/members", AddTeamMember).Methods(http.MethodPost)
+	api.HandleFunc("/api/teams/{teamId}/members/{studentId}", RemoveTeamMember).Methods(http.MethodDelete)
+	api.Handle("/api/leaderboards/teams", controllers.Cached(http.HandlerFunc(TeamLeaderboard))).Methods(http.MethodGet)
+	api.Handle("/api/leaderboard", controllers.Cached(http.HandlerFunc(StudentLeaderboard))).Methods(http.MethodGet)
+	api.HandleFunc("/api/district/leaderboard", DistrictLeaderboard).Methods(http.MethodGet)
+	api.HandleFunc("/api/leaderboards/improvement", ImprovementLeaderboard).Methods(http.MethodGet)
+	api.HandleFunc("/api/snapshots", SnapshotsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/distribution", Distribution).Methods(http.MethodGet)
+	api.HandleFunc("/api/compare", Compare).Methods(http.MethodGet)
+	api.HandleFunc("/api/boards/{slug}/publications", PublicationsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/boards/{slug}/publications", CreatePublication).Methods(http.MethodPost)
+	api.HandleFunc("/api/boards/{slug}/publications/{publicationId}", FetchPublication).Methods(http.MethodGet)
+	api.HandleFunc("/api/boards/{slug}/publications/{publicationId}/approve", ApprovePublication).Methods(http.MethodPost)
+	api.HandleFunc("/api/boards/{slug}/publications/{publicationId}/rollback", RollbackPublication).Methods(http.MethodPost)
+	api.HandleFunc("/api/boards/{slug}/publications/{publicationId}/discard", DiscardPublication).Methods(http.MethodPost)
+	api.HandleFunc("/api/boards", BoardsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/boards", SaveBoard).Methods(http.MethodPost)
+	api.Handle("/api/boards/{slug}", controllers.Cached(http.HandlerFunc(FetchBoard))).Methods(http.MethodGet)
+	api.HandleFunc("/api/boards/{slug}/events", BoardEvents).Methods(http.MethodGet)
+	api.HandleFunc("/api/boards/{slug}", SaveBoard).Methods(http.MethodPut)
+	api.HandleFunc("/api/boards/{slug}", DeleteBoard).Methods(http.MethodDelete)
+	api.HandleFunc("/api/sport_stats", StatDefinitions).Methods(http.MethodGet)
+	api.HandleFunc("/api/sport_stats", CreateStatDefinition).Methods(http.MethodPost)
+	api.HandleFunc("/api/sport_stats/{statId}", DeleteStatDefinition).Methods(http.MethodDelete)
+	api.HandleFunc("/api/sport_stats/{statId}/entries", StatEntries).Methods(http.MethodGet)
+	api.HandleFunc("/api/sport_stats/{statId}/entries", CreateStatEntry).Methods(http.MethodPost)
+	api.Handle("/api/leaderboards/sport_stats/{statId}", controllers.Cached(http.HandlerFunc(StatLeaderboard))).Methods(http.MethodGet)
+	api.HandleFunc("/api/snapshots", CreateSnapshot).Methods(http.MethodPost)
+	api.HandleFunc("/api/eligibility/rules", EligibilityRules).Methods(http.MethodGet)
+	api.HandleFunc("/api/eligibility/rules", CreateEligibilityRule).Methods(http.MethodPost)
+	api.HandleFunc("/api/eligibility/rules/{ruleId}", DeleteEligibilityRule).Methods(http.MethodDelete)
+	api.HandleFunc("/api/eligibility/report", EligibilityReport).Methods(http.MethodGet)
+	api.HandleFunc("/api/hall_of_fame/records", RecordsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/hall_of_fame/records/{recordKey}", RecordHistory).Methods(http.MethodGet)
+	api.HandleFunc("/api/hall_of_fame/seasons", SeasonWinners).Methods(http.MethodGet)
+	api.HandleFunc("/api/hall_of_fame/seasons", ArchiveSeason).Methods(http.MethodPost)
+	api.HandleFunc("/api/cohorts", CohortsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/cohorts/promotions", PromotionsIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/cohorts/promotions", PromoteSchool).Methods(http.MethodPost)
+	api.HandleFunc("/api/webhooks", WebhooksIndex).Methods(http.MethodGet)
+	api.HandleFunc("/api/webhooks", CreateWebhook).Methods(http.MethodPost)
+	api.HandleFunc("/api/webhooks/{webhookId}", FetchWebhook).Methods(http.MethodGet)
+	api.HandleFunc("/api/webhooks/{webhookId}", DeleteWebhook).Methods(http.MethodDelete)
+	api.HandleFunc("/api/webhooks/{webhookId}/deliveries", WebhookDeliveries).Methods(http.MethodGet)
+	api.HandleFunc("/api/webhooks/{webhookId}/deliveries/{deliveryId}/retry", RetryWebhookDelivery).Methods(http.MethodPost)
+	api.HandleFunc("/api/metrics", controllers.Metrics).Methods(http.MethodGet)
+
+	// Every route must be described for its requests to be validated
+	for _, route := range controllers.UndocumentedRoutes(router) {
+		log.Println("OPENAPI: undocumented route " + route)
+	}
+
+	// Promote students once a school year has ended
+	go controllers.RunPromotions(time.Hour)
+
+	// Send webhook deliveries, retrying failed ones as they fall due
+	go controllers.RunWebhooks(time.Minute)
 
 	// start the server on port 8000
 
 	log.Fatal(http.ListenAndServe(":8000",
 		handlers.CORS(
-			handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"}),
-			handlers.AllowedMethods([]string{"GET", "POST", "PUT", "HEAD", "OPTIONS"}),
-			handlers.AllowedOrigins([]string{"*"}))(router)))
+			handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "If-None-Match", "If-Modified-Since",
+				"X-API-Key"}),
+			handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"}),
+			handlers.AllowedOrigins([]string{"*"}),
+			handlers.ExposedHeaders([]string{"Link", "Deprecation", "API-Version", "ETag", "Retry-After",
+				"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"}))(controllers.Versioned(router))))
 }
\ No newline at end of file
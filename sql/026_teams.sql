@@ -0,0 +1,16 @@
+-- Teams group students beyond the free-text students.sport column.
+CREATE TABLE IF NOT EXISTS leaderboard.teams (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(128) NOT NULL,
+	sport VARCHAR(64) NOT NULL,
+	level VARCHAR(32) NOT NULL,
+	t_stamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS leaderboard.team_members (
+	team_id INT NOT NULL,
+	student_id INT NOT NULL,
+	PRIMARY KEY (team_id, student_id),
+	FOREIGN KEY (team_id) REFERENCES leaderboard.teams(id) ON DELETE CASCADE,
+	FOREIGN KEY (student_id) REFERENCES leaderboard.students(id) ON DELETE CASCADE
+);
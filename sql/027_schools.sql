@@ -0,0 +1,12 @@
+-- Every record belongs to a school; district accounts may read across schools.
+CREATE TABLE IF NOT EXISTS leaderboard.schools (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(128) NOT NULL
+);
+
+INSERT IGNORE INTO leaderboard.schools(id, name) VALUES(1, 'Default School');
+
+ALTER TABLE leaderboard.students ADD COLUMN school_id INT NOT NULL DEFAULT 1;
+ALTER TABLE leaderboard.students ADD INDEX students_school (school_id);
+ALTER TABLE leaderboard.teams ADD COLUMN school_id INT NOT NULL DEFAULT 1;
+ALTER TABLE leaderboard.teams ADD INDEX teams_school (school_id);
@@ -0,0 +1,25 @@
+-- Credits earned and per-term results feed the athletic eligibility rules.
+ALTER TABLE leaderboard.students ADD COLUMN credits DECIMAL(5,1) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS leaderboard.student_terms (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	student_id INT NOT NULL,
+	term VARCHAR(32) NOT NULL,
+	gpa DECIMAL(4,2) NOT NULL,
+	credits DECIMAL(5,1) NOT NULL DEFAULT 0,
+	ends_on DATE NOT NULL,
+	UNIQUE KEY student_term (student_id, term),
+	FOREIGN KEY (student_id) REFERENCES leaderboard.students(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS leaderboard.eligibility_rules (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	sport VARCHAR(64) NOT NULL,
+	level VARCHAR(32) NOT NULL DEFAULT '',
+	min_gpa DECIMAL(4,2) NOT NULL DEFAULT 0,
+	min_credits DECIMAL(5,1) NOT NULL DEFAULT 0,
+	grace_terms INT NOT NULL DEFAULT 0,
+	UNIQUE KEY rule_scope (school_id, sport, level)
+);
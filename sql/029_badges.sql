@@ -0,0 +1,21 @@
+-- Badge definitions override the built-in badges of the same key per school.
+CREATE TABLE IF NOT EXISTS leaderboard.badges (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	badge_key VARCHAR(64) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	rule TEXT NOT NULL,
+	UNIQUE KEY school_badge (school_id, badge_key)
+);
+
+CREATE TABLE IF NOT EXISTS leaderboard.badge_awards (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	student_id INT NOT NULL,
+	badge_key VARCHAR(64) NOT NULL,
+	term VARCHAR(32) NOT NULL,
+	awarded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY student_badge_term (student_id, badge_key, term),
+	FOREIGN KEY (student_id) REFERENCES leaderboard.students(id) ON DELETE CASCADE
+);
@@ -0,0 +1,17 @@
+-- Point-in-time copies of every student's standing, for comparing progress
+-- between arbitrary dates rather than whole terms.
+CREATE TABLE IF NOT EXISTS leaderboard.snapshots (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	label VARCHAR(64) NOT NULL,
+	taken_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS leaderboard.snapshot_entries (
+	snapshot_id INT NOT NULL,
+	student_id INT NOT NULL,
+	gpa DECIMAL(4,2) NOT NULL,
+	credits DECIMAL(5,1) NOT NULL,
+	PRIMARY KEY (snapshot_id, student_id),
+	FOREIGN KEY (snapshot_id) REFERENCES leaderboard.snapshots(id) ON DELETE CASCADE
+);
@@ -0,0 +1,23 @@
+-- Athletic performance statistics, defined per sport.
+CREATE TABLE IF NOT EXISTS leaderboard.stat_definitions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	sport VARCHAR(64) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	unit VARCHAR(32) NOT NULL DEFAULT '',
+	stat_precision INT NOT NULL DEFAULT 2,
+	lower_is_better BOOLEAN NOT NULL DEFAULT FALSE,
+	UNIQUE KEY sport_stat (school_id, sport, name)
+);
+
+CREATE TABLE IF NOT EXISTS leaderboard.stat_entries (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	stat_id INT NOT NULL,
+	student_id INT NOT NULL,
+	value DOUBLE NOT NULL,
+	recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX stat_student (stat_id, student_id),
+	FOREIGN KEY (stat_id) REFERENCES leaderboard.stat_definitions(id) ON DELETE CASCADE,
+	FOREIGN KEY (student_id) REFERENCES leaderboard.students(id) ON DELETE CASCADE
+);
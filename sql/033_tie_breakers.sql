@@ -0,0 +1,4 @@
+-- The "updated" tie-breaker orders students by when their record, terms or
+-- stats last changed. t_stamp stays the time the student was created.
+ALTER TABLE leaderboard.students ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+UPDATE leaderboard.students SET updated_at = t_stamp;
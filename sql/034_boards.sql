@@ -0,0 +1,10 @@
+-- Leaderboards defined by administrators, served at /api/boards/{slug}.
+CREATE TABLE IF NOT EXISTS leaderboard.boards (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	slug VARCHAR(64) NOT NULL,
+	definition TEXT NOT NULL,
+	created_by VARCHAR(64) NOT NULL,
+	t_stamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	UNIQUE KEY school_slug (school_id, slug)
+);
@@ -0,0 +1,16 @@
+-- Each student's rank on each board over time. After every change to a
+-- school's data its boards are recomputed, and a row is written for each
+-- student whose rank or score has changed since their last row; a snapshot
+-- writes one for every student.
+CREATE TABLE IF NOT EXISTS leaderboard.rank_history (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	board VARCHAR(64) NOT NULL,
+	student_id INT NOT NULL,
+	rank_position INT NOT NULL,
+	score DOUBLE NOT NULL,
+	snapshot_id INT NULL,
+	recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX board_student (school_id, board, student_id, recorded_at),
+	FOREIGN KEY (snapshot_id) REFERENCES leaderboard.snapshots(id) ON DELETE SET NULL
+);
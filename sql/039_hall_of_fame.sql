@@ -0,0 +1,32 @@
+-- The leaders of each board at the end of a season. Names are copied so
+-- that winners outlive their student records.
+CREATE TABLE IF NOT EXISTS leaderboard.season_winners (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	season VARCHAR(64) NOT NULL,
+	board VARCHAR(64) NOT NULL,
+	rank_position INT NOT NULL,
+	student_id INT NOT NULL,
+	firstName VARCHAR(64) NOT NULL,
+	lastName VARCHAR(64) NOT NULL,
+	score DOUBLE NOT NULL,
+	archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY season_board_student (school_id, season, board, student_id),
+	UNIQUE KEY season_board_rank (school_id, season, board, rank_position, student_id)
+);
+
+-- Every time an all-time record was set. The latest row for a key is the
+-- standing record.
+CREATE TABLE IF NOT EXISTS leaderboard.records (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	record_key VARCHAR(64) NOT NULL,
+	student_id INT NOT NULL,
+	firstName VARCHAR(64) NOT NULL,
+	lastName VARCHAR(64) NOT NULL,
+	value DOUBLE NOT NULL,
+	detail VARCHAR(128) NOT NULL DEFAULT '',
+	achieved_on TIMESTAMP NULL,
+	set_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX school_key (school_id, record_key)
+);
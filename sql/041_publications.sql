@@ -0,0 +1,17 @@
+-- Frozen copies of a board's standings. A publication starts as a draft for
+-- review; approving it publishes it and retires the previously published
+-- one. Public endpoints serve only the published publication.
+CREATE TABLE IF NOT EXISTS leaderboard.publications (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	board VARCHAR(64) NOT NULL,
+	status ENUM('draft', 'published', 'retired', 'discarded') NOT NULL DEFAULT 'draft',
+	note VARCHAR(255) NOT NULL DEFAULT '',
+	definition TEXT NOT NULL,
+	entries MEDIUMTEXT NOT NULL,
+	created_by VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	approved_by VARCHAR(64) NULL,
+	published_at TIMESTAMP NULL,
+	INDEX school_board_status (school_id, board, status)
+);
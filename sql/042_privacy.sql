@@ -0,0 +1,16 @@
+-- How a school's public leaderboards hide individual students.
+CREATE TABLE IF NOT EXISTS leaderboard.public_settings (
+	school_id INT PRIMARY KEY,
+	name_style VARCHAR(16) NOT NULL DEFAULT 'initials',
+	band_width DOUBLE NOT NULL DEFAULT 0.5,
+	min_group_size INT NOT NULL DEFAULT 5
+);
+
+-- Students who opted out of public leaderboards or chose a display alias.
+CREATE TABLE IF NOT EXISTS leaderboard.student_privacy (
+	student_id INT PRIMARY KEY,
+	school_id INT NOT NULL,
+	opt_out BOOLEAN NOT NULL DEFAULT FALSE,
+	alias VARCHAR(64) NOT NULL DEFAULT '',
+	FOREIGN KEY (student_id) REFERENCES leaderboard.students(id) ON DELETE CASCADE
+);
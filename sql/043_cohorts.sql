@@ -0,0 +1,19 @@
+-- Graduation cohorts. grad_year and grade_level are 0 when unknown. The
+-- annual promotion archives graduates rather than deleting them.
+ALTER TABLE leaderboard.students ADD COLUMN grad_year SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE leaderboard.students ADD COLUMN grade_level TINYINT NOT NULL DEFAULT 0;
+ALTER TABLE leaderboard.students ADD COLUMN archived_at TIMESTAMP NULL;
+ALTER TABLE leaderboard.students ADD INDEX students_cohort (school_id, grad_year);
+
+-- Every promotion run, scheduled or by hand. school_year is the year in
+-- which the school year that ended was completed.
+CREATE TABLE IF NOT EXISTS leaderboard.promotions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	school_year SMALLINT NOT NULL,
+	promoted INT NOT NULL,
+	graduated INT NOT NULL,
+	promoted_by VARCHAR(64) NOT NULL DEFAULT '',
+	promoted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX school_year (school_id, school_year)
+);
@@ -0,0 +1,34 @@
+-- Outgoing webhooks. events and boards are comma separated; the secret signs
+-- every delivery and is only shown when the webhook is created.
+CREATE TABLE IF NOT EXISTS leaderboard.webhooks (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	school_id INT NOT NULL,
+	url VARCHAR(2048) NOT NULL,
+	events VARCHAR(255) NOT NULL,
+	boards VARCHAR(1024) NOT NULL,
+	secret VARCHAR(128) NOT NULL,
+	created_by VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX school (school_id)
+);
+
+-- Every event sent, or to be sent, to a webhook. A delivery is retried with
+-- exponential backoff until it succeeds or runs out of attempts, when it is
+-- dead-lettered until someone retries it by hand.
+CREATE TABLE IF NOT EXISTS leaderboard.webhook_deliveries (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	webhook_id INT NOT NULL,
+	school_id INT NOT NULL,
+	event VARCHAR(64) NOT NULL,
+	payload MEDIUMTEXT NOT NULL,
+	status ENUM('pending', 'delivered', 'dead') NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NULL,
+	last_status_code INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	delivered_at TIMESTAMP NULL,
+	INDEX webhook (webhook_id, id),
+	INDEX due (status, next_attempt_at),
+	FOREIGN KEY (webhook_id) REFERENCES leaderboard.webhooks(id) ON DELETE CASCADE
+);